@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	vault "github.com/hashicorp/vault/api"
 	"github.com/spf13/viper"
 	"log"
@@ -9,25 +10,141 @@ import (
 
 const appName = "VaultBackup"
 
+// defaultVaultMaxRetries matches the Vault client default.
+const defaultVaultMaxRetries = 2
+
 type AppConfig struct {
-	AppName           string
-	VaultConfig       VaultConfig
-	GoogleDriveConfig GoogleDriveConfig
+	AppName            string
+	VaultConfig        VaultConfig
+	GoogleDriveConfig  GoogleDriveConfig
+	StatusServer       StatusServerConfig
+	Watchdog           WatchdogConfig
+	Heartbeat          HeartbeatConfig
+	Notifiers          []NotifierConfig
+	NotificationRoutes []NotificationRoute
+	TemplatesDir       string
+	Digest             DigestConfig
+	Outbox             OutboxConfig
+	Secrets            SecretsConfig
+	Events             EventsConfig
+	Polling            PollingConfig
+	SnapshotPreCheck   SnapshotPreCheckConfig
+	BackupMode         string
+	LogicalBackup      LogicalBackupConfig
+}
+
+// LogicalBackupConfig configures the logical backup mode, which exports KV v2
+// mounts into an encrypted archive instead of taking a raft snapshot.
+type LogicalBackupConfig struct {
+	Mounts        []string
+	AllVersions   bool
+	EncryptionKey SecretRef
+}
+
+// SnapshotPreCheckConfig controls the sys/health and autopilot checks run
+// before every snapshot.
+type SnapshotPreCheckConfig struct {
+	Enabled                 bool
+	RequireHealthyAutopilot bool
+	RetryInterval           string
+	MaxWait                 string
+}
+
+// PollingConfig configures change detection by walking KV v2 metadata, used
+// when the events API is unavailable (mode fallback) or in addition to it.
+type PollingConfig struct {
+	Mode      string
+	Interval  string
+	Mounts    []string
+	StateFile string
+}
+
+// EventsConfig selects and filters the Vault events that trigger backups
+// and tunes the websocket subscription.
+type EventsConfig struct {
+	Types        []string
+	Filter       string
+	IncludePaths []string
+	ExcludePaths []string
+	Debounce     string
+	MaxDelay     string
+	MinInterval  string
+
+	ReconnectInitialBackoff string
+	ReconnectMaxBackoff     string
+	PingInterval            string
+	DisconnectAlertAfter    string
+	CatchUpBackup           bool
+}
+
+type SecretsConfig struct {
+	GoogleDrive SecretRef
+	Email       SecretRef
+}
+
+type OutboxConfig struct {
+	Dir             string
+	MaxAttempts     int
+	InitialBackoff  string
+	MaxBackoff      string
+	FallbackChannel string
+}
+
+type DigestConfig struct {
+	Interval string
+	At       string
 }
 
 type VaultConfig struct {
 	Address                   string
+	ClusterName               string
 	AppRoleId                 string
 	AppSecretId               string
+	AppSecretIdFile           string
+	AppSecretIdWrapped        bool
 	WebSocketEventBaseUrl     string
 	ListenedEventsType        string
 	ScheduledSnapshotInterval string
 	SnapshotFolder            string
 	LogFilePath               string
+	StatusFilePath            string
 	EmailHost                 string
 	EmailHostPort             string
 	Mailbox                   string
+	EmailTLSMode              string
+	EmailCAFile               string
+	EmailTimeout              string
+	EmailAuthMechanism        string
 	NotifyEmails              []string
+	Auth                      VaultAuthConfig
+	TLS                       VaultTLSConfig
+	Namespace                 string
+	Timeout                   string
+	MaxRetries                int
+}
+
+type VaultTLSConfig struct {
+	CACert             string
+	CAPath             string
+	ClientCert         string
+	ClientKey          string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// VaultAuthConfig selects how the backuper logs in to Vault: approle
+// (default), kubernetes, jwt, cert, userpass or token_file.
+type VaultAuthConfig struct {
+	Method         string
+	Mount          string
+	Role           string
+	JWTFile        string
+	ClientCertFile string
+	ClientKeyFile  string
+	Username       string
+	Password       string
+	PasswordFile   string
+	TokenFile      string
 }
 
 type GoogleDriveConfig struct {
@@ -37,7 +154,107 @@ type GoogleDriveConfig struct {
 	BackupFileRetentionDays int
 }
 
-func GetVaultConfig(viper *viper.Viper) AppConfig {
+type WatchdogConfig struct {
+	CheckInterval      string
+	EventBackupSLA     string
+	ScheduledBackupSLA string
+}
+
+type NotifierConfig struct {
+	Name       string    `mapstructure:"name"`
+	Type       string    `mapstructure:"type"`
+	WebhookUrl string    `mapstructure:"webhook_url"`
+	HMACSecret SecretRef `mapstructure:"hmac_secret"`
+	RoutingKey SecretRef `mapstructure:"routing_key"`
+	MaxRetries int       `mapstructure:"max_retries"`
+
+	DedupWindow       string `mapstructure:"dedup_window"`
+	RateLimit         int    `mapstructure:"rate_limit"`
+	RateLimitInterval string `mapstructure:"rate_limit_interval"`
+}
+
+// NotificationRoute sends notifications matching Events (any when empty) and
+// at least MinSeverity to Channels (all when empty).
+type NotificationRoute struct {
+	Events      []string `mapstructure:"events"`
+	MinSeverity string   `mapstructure:"min_severity"`
+	Channels    []string `mapstructure:"channels"`
+}
+
+// SecretRef points at a KV secret in Vault. Field selects a single value,
+// Fields maps logical names (e.g. username) to the secret's keys.
+type SecretRef struct {
+	Mount     string            `mapstructure:"mount"`
+	Path      string            `mapstructure:"path"`
+	Field     string            `mapstructure:"field"`
+	Fields    map[string]string `mapstructure:"fields"`
+	KVVersion int               `mapstructure:"kv_version"`
+	Version   int               `mapstructure:"version"`
+}
+
+func (r SecretRef) String() string {
+	return fmt.Sprintf("%s/%s", r.Mount, r.Path)
+}
+
+func (r SecretRef) Validate() error {
+	if r.Mount == "" || r.Path == "" {
+		return fmt.Errorf("secret %q: mount and path are required", r.String())
+	}
+	if r.KVVersion != 0 && r.KVVersion != 1 && r.KVVersion != 2 {
+		return fmt.Errorf("secret %s: kv_version must be 1 or 2, got %d", r, r.KVVersion)
+	}
+	if r.Version < 0 {
+		return fmt.Errorf("secret %s: version must not be negative", r)
+	}
+	if r.Version > 0 && r.KVVersion == 1 {
+		return fmt.Errorf("secret %s: version pinning requires kv_version 2", r)
+	}
+	return nil
+}
+
+// FieldName returns the secret key mapped to the logical name.
+func (r SecretRef) FieldName(name string) string {
+	if field, ok := r.Fields[name]; ok && field != "" {
+		return field
+	}
+	return name
+}
+
+type HeartbeatConfig struct {
+	StartUrl   string
+	SuccessUrl string
+	FailUrl    string
+	Timeout    string
+}
+
+type StatusServerConfig struct {
+	Port                   string
+	BindAddress            string
+	AdminCapabilityPath    string
+	AdminCapability        string
+	JobHistoryLimit        int
+	ProgressAllowedOrigins []string
+	TLS                    StatusServerTLSConfig
+}
+
+type StatusServerTLSConfig struct {
+	Enabled           bool
+	CertFile          string
+	KeyFile           string
+	ClientCAFile      string
+	RequireClientCert bool
+	VaultPKI          VaultPKIConfig
+}
+
+type VaultPKIConfig struct {
+	Mount      string
+	Role       string
+	CommonName string
+	AltNames   []string
+	TTL        string
+}
+
+func GetVaultConfig(viper *viper.Viper) (AppConfig, error) {
 	appConfig := AppConfig{
 		AppName: appName,
 	}
@@ -49,22 +266,162 @@ func GetVaultConfig(viper *viper.Viper) AppConfig {
 		appConfig.VaultConfig.Address = os.Getenv(vault.EnvVaultAddress)
 	}
 
+	appConfig.VaultConfig.ClusterName = viper.GetString("vault.cluster_name")
+	if appConfig.VaultConfig.ClusterName == "" {
+		appConfig.VaultConfig.ClusterName = appConfig.VaultConfig.Address
+	}
+
+	appConfig.VaultConfig.Namespace = viper.GetString("vault.namespace")
+	appConfig.VaultConfig.Timeout = viper.GetString("vault.timeout")
+	appConfig.VaultConfig.MaxRetries = defaultVaultMaxRetries
+	if viper.IsSet("vault.max_retries") {
+		appConfig.VaultConfig.MaxRetries = viper.GetInt("vault.max_retries")
+	}
+	appConfig.VaultConfig.TLS.CACert = viper.GetString("vault.tls.ca_cert")
+	appConfig.VaultConfig.TLS.CAPath = viper.GetString("vault.tls.ca_path")
+	appConfig.VaultConfig.TLS.ClientCert = viper.GetString("vault.tls.client_cert")
+	appConfig.VaultConfig.TLS.ClientKey = viper.GetString("vault.tls.client_key")
+	appConfig.VaultConfig.TLS.ServerName = viper.GetString("vault.tls.server_name")
+	appConfig.VaultConfig.TLS.InsecureSkipVerify = viper.GetBool("vault.tls.insecure_skip_verify")
+
 	appConfig.VaultConfig.AppRoleId = viper.GetString("vault.app_role_id")
 	appConfig.VaultConfig.WebSocketEventBaseUrl = viper.GetString("vault.web_socket_event_base_url")
 	appConfig.VaultConfig.ListenedEventsType = viper.GetString("vault.listened_event_type")
 	appConfig.VaultConfig.ScheduledSnapshotInterval = viper.GetString("vault.scheduled_snapshot_interval")
 	appConfig.VaultConfig.SnapshotFolder = viper.GetString("vault.snapshot_folder")
 	appConfig.VaultConfig.LogFilePath = viper.GetString("vault.log_file_path")
+	appConfig.VaultConfig.StatusFilePath = viper.GetString("vault.status_file_path")
 	appConfig.VaultConfig.NotifyEmails = viper.GetStringSlice("vault.notify_email_addresses")
 	appConfig.VaultConfig.EmailHost = viper.GetString("vault.email_host")
 	appConfig.VaultConfig.EmailHostPort = viper.GetString("vault.email_host_port")
 	appConfig.VaultConfig.Mailbox = viper.GetString("vault.mailbox")
+	appConfig.VaultConfig.EmailTLSMode = viper.GetString("vault.email_tls_mode")
+	appConfig.VaultConfig.EmailCAFile = viper.GetString("vault.email_ca_file")
+	appConfig.VaultConfig.EmailTimeout = viper.GetString("vault.email_timeout")
+	appConfig.VaultConfig.EmailAuthMechanism = viper.GetString("vault.email_auth_mechanism")
 
 	appConfig.VaultConfig.AppSecretId = os.Getenv("APPROLE_SECRET_ID")
+	appConfig.VaultConfig.AppSecretIdFile = viper.GetString("vault.app_secret_id_file")
+	appConfig.VaultConfig.AppSecretIdWrapped = viper.GetBool("vault.app_secret_id_wrapped")
+
+	appConfig.VaultConfig.Auth.Method = viper.GetString("vault.auth.method")
+	appConfig.VaultConfig.Auth.Mount = viper.GetString("vault.auth.mount")
+	appConfig.VaultConfig.Auth.Role = viper.GetString("vault.auth.role")
+	appConfig.VaultConfig.Auth.JWTFile = viper.GetString("vault.auth.jwt_file")
+	appConfig.VaultConfig.Auth.ClientCertFile = viper.GetString("vault.auth.client_cert_file")
+	appConfig.VaultConfig.Auth.ClientKeyFile = viper.GetString("vault.auth.client_key_file")
+	appConfig.VaultConfig.Auth.Username = viper.GetString("vault.auth.username")
+	appConfig.VaultConfig.Auth.Password = os.Getenv("VAULT_USERPASS_PASSWORD")
+	appConfig.VaultConfig.Auth.PasswordFile = viper.GetString("vault.auth.password_file")
+	appConfig.VaultConfig.Auth.TokenFile = viper.GetString("vault.auth.token_file")
 
 	appConfig.GoogleDriveConfig.OnEventDeployFolderId = viper.GetString("google.on_event_deploy_folder_id")
 	appConfig.GoogleDriveConfig.ScheduledDeployFolderId = viper.GetString("google.scheduled_deploy_folder_id")
 	appConfig.GoogleDriveConfig.BackupFileRetentionDays = viper.GetInt("google.backup_file_retention_days")
 
-	return appConfig
+	appConfig.Events.Types = viper.GetStringSlice("events.types")
+	appConfig.Events.Filter = viper.GetString("events.filter")
+	appConfig.Events.IncludePaths = viper.GetStringSlice("events.include_paths")
+	appConfig.Events.ExcludePaths = viper.GetStringSlice("events.exclude_paths")
+	appConfig.Events.Debounce = viper.GetString("events.debounce")
+	appConfig.Events.MaxDelay = viper.GetString("events.max_delay")
+	appConfig.Events.MinInterval = viper.GetString("events.min_interval")
+	appConfig.Events.ReconnectInitialBackoff = viper.GetString("events.reconnect_initial_backoff")
+	appConfig.Events.ReconnectMaxBackoff = viper.GetString("events.reconnect_max_backoff")
+	appConfig.Events.PingInterval = viper.GetString("events.ping_interval")
+	appConfig.Events.DisconnectAlertAfter = viper.GetString("events.disconnect_alert_after")
+	appConfig.Events.CatchUpBackup = viper.GetBool("events.catch_up_backup")
+
+	appConfig.Polling.Mode = viper.GetString("change_polling.mode")
+	appConfig.Polling.Interval = viper.GetString("change_polling.interval")
+	appConfig.Polling.Mounts = viper.GetStringSlice("change_polling.mounts")
+	appConfig.Polling.StateFile = viper.GetString("change_polling.state_file")
+
+	appConfig.SnapshotPreCheck.Enabled = true
+	if viper.IsSet("snapshot_precheck.enabled") {
+		appConfig.SnapshotPreCheck.Enabled = viper.GetBool("snapshot_precheck.enabled")
+	}
+	appConfig.SnapshotPreCheck.RequireHealthyAutopilot = true
+	if viper.IsSet("snapshot_precheck.require_healthy_autopilot") {
+		appConfig.SnapshotPreCheck.RequireHealthyAutopilot = viper.GetBool("snapshot_precheck.require_healthy_autopilot")
+	}
+	appConfig.SnapshotPreCheck.RetryInterval = viper.GetString("snapshot_precheck.retry_interval")
+	appConfig.SnapshotPreCheck.MaxWait = viper.GetString("snapshot_precheck.max_wait")
+
+	appConfig.BackupMode = viper.GetString("backup_mode")
+	appConfig.LogicalBackup.Mounts = viper.GetStringSlice("logical_backup.mounts")
+	appConfig.LogicalBackup.AllVersions = viper.GetBool("logical_backup.all_versions")
+	if err := viper.UnmarshalKey("logical_backup.encryption_key", &appConfig.LogicalBackup.EncryptionKey); err != nil {
+		return appConfig, fmt.Errorf("GetVaultConfig: unable to parse logical_backup.encryption_key %w", err)
+	}
+
+	appConfig.Watchdog.CheckInterval = viper.GetString("watchdog.check_interval")
+	appConfig.Watchdog.EventBackupSLA = viper.GetString("watchdog.event_backup_sla")
+	appConfig.Watchdog.ScheduledBackupSLA = viper.GetString("watchdog.scheduled_backup_sla")
+
+	appConfig.Heartbeat.StartUrl = viper.GetString("heartbeat.start_url")
+	appConfig.Heartbeat.SuccessUrl = viper.GetString("heartbeat.success_url")
+	appConfig.Heartbeat.FailUrl = viper.GetString("heartbeat.fail_url")
+	appConfig.Heartbeat.Timeout = viper.GetString("heartbeat.timeout")
+
+	if err := viper.UnmarshalKey("notifiers", &appConfig.Notifiers); err != nil {
+		return appConfig, fmt.Errorf("GetVaultConfig: unable to parse notifiers %w", err)
+	}
+	appConfig.TemplatesDir = viper.GetString("notification_templates_dir")
+	appConfig.Digest.Interval = viper.GetString("notification_digest.interval")
+	appConfig.Digest.At = viper.GetString("notification_digest.at")
+	appConfig.Outbox.Dir = viper.GetString("notification_outbox.dir")
+	appConfig.Outbox.MaxAttempts = viper.GetInt("notification_outbox.max_attempts")
+	appConfig.Outbox.InitialBackoff = viper.GetString("notification_outbox.initial_backoff")
+	appConfig.Outbox.MaxBackoff = viper.GetString("notification_outbox.max_backoff")
+	appConfig.Outbox.FallbackChannel = viper.GetString("notification_outbox.fallback_channel")
+	if err := viper.UnmarshalKey("notification_routes", &appConfig.NotificationRoutes); err != nil {
+		return appConfig, fmt.Errorf("GetVaultConfig: unable to parse notification_routes %w", err)
+	}
+
+	// defaults apply only when the key is absent, a partial override must not
+	// inherit the default field mapping
+	appConfig.Secrets.GoogleDrive = SecretRef{Mount: "google_drive", Path: "service_account"}
+	if viper.IsSet("secrets.google_drive") {
+		appConfig.Secrets.GoogleDrive = SecretRef{}
+		if err := viper.UnmarshalKey("secrets.google_drive", &appConfig.Secrets.GoogleDrive); err != nil {
+			return appConfig, fmt.Errorf("GetVaultConfig: unable to parse secrets.google_drive %w", err)
+		}
+	}
+	appConfig.Secrets.Email = SecretRef{
+		Mount:  "navarra-lab.com",
+		Path:   "email/bucket",
+		Fields: map[string]string{"username": "login", "password": "pass"},
+	}
+	if viper.IsSet("secrets.email") {
+		appConfig.Secrets.Email = SecretRef{}
+		if err := viper.UnmarshalKey("secrets.email", &appConfig.Secrets.Email); err != nil {
+			return appConfig, fmt.Errorf("GetVaultConfig: unable to parse secrets.email %w", err)
+		}
+	}
+	if err := appConfig.Secrets.GoogleDrive.Validate(); err != nil {
+		return appConfig, fmt.Errorf("GetVaultConfig: secrets.google_drive %w", err)
+	}
+	if err := appConfig.Secrets.Email.Validate(); err != nil {
+		return appConfig, fmt.Errorf("GetVaultConfig: secrets.email %w", err)
+	}
+
+	appConfig.StatusServer.Port = viper.GetString("status_server.port")
+	appConfig.StatusServer.BindAddress = viper.GetString("status_server.bind_address")
+	appConfig.StatusServer.AdminCapabilityPath = viper.GetString("status_server.admin_capability_path")
+	appConfig.StatusServer.AdminCapability = viper.GetString("status_server.admin_capability")
+	appConfig.StatusServer.JobHistoryLimit = viper.GetInt("status_server.job_history_limit")
+	appConfig.StatusServer.ProgressAllowedOrigins = viper.GetStringSlice("status_server.progress_allowed_origins")
+	appConfig.StatusServer.TLS.Enabled = viper.GetBool("status_server.tls.enabled")
+	appConfig.StatusServer.TLS.CertFile = viper.GetString("status_server.tls.cert_file")
+	appConfig.StatusServer.TLS.KeyFile = viper.GetString("status_server.tls.key_file")
+	appConfig.StatusServer.TLS.ClientCAFile = viper.GetString("status_server.tls.client_ca_file")
+	appConfig.StatusServer.TLS.RequireClientCert = viper.GetBool("status_server.tls.require_client_cert")
+	appConfig.StatusServer.TLS.VaultPKI.Mount = viper.GetString("status_server.tls.vault_pki.mount")
+	appConfig.StatusServer.TLS.VaultPKI.Role = viper.GetString("status_server.tls.vault_pki.role")
+	appConfig.StatusServer.TLS.VaultPKI.CommonName = viper.GetString("status_server.tls.vault_pki.common_name")
+	appConfig.StatusServer.TLS.VaultPKI.AltNames = viper.GetStringSlice("status_server.tls.vault_pki.alt_names")
+	appConfig.StatusServer.TLS.VaultPKI.TTL = viper.GetString("status_server.tls.vault_pki.ttl")
+
+	return appConfig, nil
 }
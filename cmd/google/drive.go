@@ -2,11 +2,14 @@ package google
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	vault "github.com/hashicorp/vault/api"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
+	"io"
 	"log"
 	"os"
 	"sync"
@@ -67,7 +70,7 @@ func (g *DriveClient) GetListOfOutdatedFiles() (*[]drive.File, error) {
 	return &outdatedBackupFiles, nil
 }
 
-func (g *DriveClient) RemoveOutdatedBackups() (int, error) {
+func (g *DriveClient) RemoveOutdatedBackups(onDelete func(f drive.File)) (int, error) {
 	var numOfDeletedFiles = 0
 
 	outdatedBackupFiles, err := g.GetListOfOutdatedFiles()
@@ -87,6 +90,9 @@ func (g *DriveClient) RemoveOutdatedBackups() (int, error) {
 				errorChan <- err
 			} else {
 				numOfDeletedFiles = numOfDeletedFiles + 1
+				if onDelete != nil {
+					onDelete(f)
+				}
 			}
 		}(f)
 		wg.Wait()
@@ -106,19 +112,21 @@ func (g *DriveClient) RemoveOutdatedBackups() (int, error) {
 	return numOfDeletedFiles, nil
 }
 
-func (g *DriveClient) DeployBackupToGoogleDrive(backupFilePath, googleDriveFolderId string) (*string, error) {
+func (g *DriveClient) DeployBackupToGoogleDrive(
+	ctx context.Context,
+	backupFilePath, googleDriveFolderId string,
+	onProgress func(current, total int64)) (*string, error) {
 	file, err := os.Open(backupFilePath)
 	if err != nil {
-		log.Fatalf("DeployBackupToGoogleDrive: unable to load a file %s, %v", backupFilePath, err)
+		return nil, fmt.Errorf("DeployBackupToGoogleDrive: unable to load a file %s, %w", backupFilePath, err)
 	}
+	defer file.Close()
 
 	info, err := file.Stat()
 	if err != nil {
 		return nil, fmt.Errorf("DeployBackupToGoogleDrive: unable to get fileInfo %s, %w", backupFilePath, err)
 	}
 
-	defer file.Close()
-
 	log.Printf("Uploading file %s to folder: %s", info.Name(), googleDriveFolderId)
 	fileMetadata := &drive.File{
 		Name:    info.Name(),
@@ -129,7 +137,12 @@ func (g *DriveClient) DeployBackupToGoogleDrive(backupFilePath, googleDriveFolde
 		Create(fileMetadata).
 		Media(file).
 		SupportsAllDrives(true).
-		ProgressUpdater(func(now, size int64) { log.Printf("%d, %d\r", now, size) }).
+		ProgressUpdater(func(now, size int64) {
+			if onProgress != nil {
+				onProgress(now, size)
+			}
+		}).
+		Context(ctx).
 		Do()
 
 	if err != nil {
@@ -138,3 +151,36 @@ func (g *DriveClient) DeployBackupToGoogleDrive(backupFilePath, googleDriveFolde
 
 	return &res.Id, nil
 }
+
+// VerifyBackup compares size and MD5 checksum reported by Drive for fileId
+// with the local backup file.
+func (g *DriveClient) VerifyBackup(ctx context.Context, fileId, backupFilePath string) error {
+	remote, err := g.service.Files.Get(fileId).
+		Fields("id, name, size, md5Checksum").
+		SupportsAllDrives(true).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return fmt.Errorf("VerifyBackup: unable to get file metadata %s, %w", fileId, err)
+	}
+
+	file, err := os.Open(backupFilePath)
+	if err != nil {
+		return fmt.Errorf("VerifyBackup: unable to open local file %s, %w", backupFilePath, err)
+	}
+	defer file.Close()
+
+	hash := md5.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("VerifyBackup: unable to read local file %s, %w", backupFilePath, err)
+	}
+
+	if remote.Size != size {
+		return fmt.Errorf("VerifyBackup: size mismatch for %s, local %d remote %d", remote.Name, size, remote.Size)
+	}
+	if localSum := hex.EncodeToString(hash.Sum(nil)); remote.Md5Checksum != localSum {
+		return fmt.Errorf("VerifyBackup: checksum mismatch for %s, local %s remote %s", remote.Name, localSum, remote.Md5Checksum)
+	}
+	return nil
+}
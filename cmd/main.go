@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 	"vault_backup/cmd/config"
 	"vault_backup/cmd/google"
 	"vault_backup/cmd/services"
@@ -20,6 +22,9 @@ func main() {
 	ctx := context.Background()
 
 	flag.String("config", "", "path to the yaml config file")
+	flag.String("import-kv", "", "restore a logical backup archive into Vault and exit")
+	flag.Bool("import-dry-run", false, "with -import-kv, only log what would be written")
+	flag.Bool("import-overwrite", false, "with -import-kv, also write secrets that already exist")
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
 
@@ -27,28 +32,47 @@ func main() {
 	if err != nil {
 		log.Fatalf("main: error while parsing run parameters")
 	}
+	importFilePath, _ := pflag.CommandLine.GetString("import-kv")
+	importDryRun, _ := pflag.CommandLine.GetBool("import-dry-run")
+	importOverwrite, _ := pflag.CommandLine.GetBool("import-overwrite")
 
 	viperCnf, err := viperInit(configFilePath)
 	if err != nil {
 		log.Fatalf("main: error while loading config file %s, %v", configFilePath, err)
 	}
 
-	appConfig := config.GetVaultConfig(viperCnf)
+	appConfig, err := config.GetVaultConfig(viperCnf)
+	if err != nil {
+		log.Fatalf("main: invalid configuration in %s, %v", configFilePath, err)
+	}
 
 	logFile, err := os.OpenFile(appConfig.VaultConfig.LogFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		log.Fatalf("main: error while creating log file %s, %v \n", appConfig.VaultConfig.LogFilePath, err)
 	}
-	log.SetOutput(logFile)
+	if importFilePath != "" {
+		// imports are run by hand, show the progress on the terminal as well
+		log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	} else {
+		log.SetOutput(logFile)
+	}
 
-	v, authToken, err := services.GetVaultAppRoleClient(ctx, appConfig)
+	v, err := services.GetVaultClient(ctx, appConfig)
 	if err != nil {
 		log.Fatalf("unable to initialize v connection %s: %v", appConfig.VaultConfig.Address, err)
 	}
 
-	gDriveJsonSecret, err := v.GetKVSecret(ctx, "google_drive", "service_account")
+	if importFilePath != "" {
+		options := services.KVImportOptions{DryRun: importDryRun, Overwrite: importOverwrite}
+		if err := importKVArchive(ctx, v, appConfig, importFilePath, options); err != nil {
+			log.Fatalf("unable to import %s %v", importFilePath, err)
+		}
+		return
+	}
+
+	gDriveJsonSecret, err := v.ReadSecret(ctx, appConfig.Secrets.GoogleDrive)
 	if err != nil {
-		log.Fatalf("unable to obtain GoogleDrive json secret from valt %v", err)
+		log.Fatalf("unable to obtain GoogleDrive json secret from vault %v", err)
 	}
 
 	googleDrive, err := google.GetGoogleDriveClient(ctx, appConfig, *gDriveJsonSecret)
@@ -56,10 +80,19 @@ func main() {
 		log.Fatalf("unable to initialize GoogleDriveService %v", err)
 	}
 
+	notifierRegistry, err := services.GetNotifierRegistry(ctx, &appConfig, v)
+	if err != nil {
+		log.Fatalf("unable to initialize notifiers %v", err)
+	}
+	notifier, err := notifierRegistry.FanOut(appConfig.NotificationRoutes)
+	if err != nil {
+		log.Fatalf("unable to initialize notification routes %v", err)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		v.RenewTokenPeriodically(ctx, authToken, appConfig)
+		v.RenewTokenPeriodically(ctx, appConfig, notifier)
 		wg.Done()
 	}()
 
@@ -67,29 +100,57 @@ func main() {
 		wg.Wait()
 	}()
 
-	emailNotifierSecret, err := v.GetKVSecret(ctx, "navarra-lab.com", "email/bucket")
+	appStatus, err := services.GetFileAppStatus(appConfig.VaultConfig.StatusFilePath, time.Now())
+	if err != nil {
+		log.Fatalf("unable to load application status %v", err)
+	}
+
+	watchdog, err := services.GetBackupWatchdog(&appConfig, appStatus, notifier)
 	if err != nil {
-		log.Fatalf("unable to obtain GoogleDrive json secret from valt %v", err)
+		log.Fatalf("unable to initialize BackupWatchdog %v", err)
 	}
+	go watchdog.Run(ctx)
 
-	emailNotifier, err := services.GetEmailNotifier(
-		appConfig.VaultConfig.NotifyEmails,
-		emailNotifierSecret.Data["login"].(string),
-		emailNotifierSecret.Data["pass"].(string),
-		appConfig.VaultConfig.EmailHost,
-		appConfig.VaultConfig.EmailHostPort,
-		appConfig.VaultConfig.Mailbox)
+	heartbeat, err := services.GetHeartbeat(appConfig.Heartbeat)
 	if err != nil {
-		log.Fatalf("unable to initialize EmailNotifier %v", err)
+		log.Fatalf("unable to initialize Heartbeat %v", err)
 	}
 
-	backupScheduler, err := services.GetBackupScheduler(v, &appConfig, googleDrive, &emailNotifier, *authToken)
+	backupScheduler, err := services.GetBackupScheduler(v, &appConfig, googleDrive, notifier, appStatus, heartbeat)
 	if err != nil {
 		log.Fatalf("unable to initialize BackupScheduler %v", err)
 	}
+
+	statusServer := services.GetStatusServer(&appConfig, v, backupScheduler, watchdog)
+	if err := statusServer.StartServer(ctx, appConfig.StatusServer.Port); err != nil {
+		log.Fatalf("unable to start StatusServer %v", err)
+	}
+
 	backupScheduler.CreateVaultBackups()
 }
 
+// importKVArchive restores a logical backup into the configured Vault, using
+// the same encryption key the backups are written with.
+func importKVArchive(ctx context.Context, v *services.Vault, appConfig config.AppConfig, filePath string, options services.KVImportOptions) error {
+	key, err := v.GetKVArchiveKey(ctx, appConfig.LogicalBackup.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("importKVArchive: %w", err)
+	}
+	archive, err := services.ReadKVArchive(filePath, key)
+	if err != nil {
+		return fmt.Errorf("importKVArchive: %w", err)
+	}
+	log.Printf("Importing %s of %s taken at %s", filePath, archive.Cluster, archive.CreatedAt.Format(time.RFC3339))
+
+	result, err := v.ImportKVv2(ctx, archive, options)
+	log.Printf("Imported %d secrets with %d versions, skipped %d, failed %d, changed concurrently %d",
+		result.Imported, result.Versions, result.Skipped, result.Failed, result.Conflicts)
+	if err != nil {
+		return fmt.Errorf("importKVArchive: %w", err)
+	}
+	return nil
+}
+
 func viperInit(configFilePath string) (*viper.Viper, error) {
 	cwd, _ := os.Getwd()
 	log.Printf("current working directory %s", cwd)
@@ -1,27 +1,38 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-co-op/gocron"
-	"github.com/gorilla/websocket"
-	vault "github.com/hashicorp/vault/api"
+	"google.golang.org/api/drive/v3"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
 	"vault_backup/cmd/config"
 	"vault_backup/cmd/google"
 )
 
-const vaultWebsocketPath = "v1/sys/events/subscribe"
+const (
+	vaultWebsocketPath     = "v1/sys/events/subscribe"
+	scheduledBackupTag     = "scheduled-backup"
+	retentionCleanupTag    = "retention-cleanup"
+	googleDriveDestination = "google_drive"
+
+	defaultPreCheckRetryInterval = 30 * time.Second
+	defaultPreCheckMaxWait       = 10 * time.Minute
+)
 
 type Event int64
 
 const (
 	WssEvent Event = iota
 	ScheduledEvent
+	ManualEvent
 )
 
 func (e Event) String() string {
@@ -30,6 +41,8 @@ func (e Event) String() string {
 		return "services event"
 	case ScheduledEvent:
 		return "scheduled event"
+	case ManualEvent:
+		return "manual event"
 	}
 	return "unknown"
 }
@@ -37,65 +50,275 @@ func (e Event) String() string {
 type BackupType struct {
 	eventType    Event
 	gDriveFileId string
+	jobId        string
+	events       []VaultEvent
 }
 
 type BackupScheduler struct {
 	vault             *Vault
 	appConfig         *config.AppConfig
 	googleDriveClient *google.DriveClient
-	wsConnection      *websocket.Conn
+	subscriptions     []*EventSubscription
+	eventFilter       *eventFilter
+	coalescer         *eventCoalescer
+	poller            *ChangePoller
 	scheduler         *gocron.Scheduler
-	notifier          *EmailNotifier
+	notifier          Notifier
+	jobs              *JobRegistry
+	progress          *ProgressHub
+	retention         *retentionTracker
+	appStatus         *FileAppStatus
+	heartbeat         *Heartbeat
+	digest            *Digest
+	events            chan BackupType
+
+	preCheckRetryInterval time.Duration
+	preCheckMaxWait       time.Duration
+	backupMode            string
 }
 
 func GetBackupScheduler(
 	vault *Vault,
 	appConfig *config.AppConfig,
 	googleDriveClient *google.DriveClient,
-	emailNotifier *EmailNotifier,
-	token vault.Secret) (*BackupScheduler, error) {
+	notifier Notifier,
+	appStatus *FileAppStatus,
+	heartbeat *Heartbeat) (*BackupScheduler, error) {
 
-	wsURL := fmt.Sprintf("%s/%s/%s?json=true",
-		appConfig.VaultConfig.WebSocketEventBaseUrl,
-		vaultWebsocketPath,
-		appConfig.VaultConfig.ListenedEventsType)
+	pollingMode := appConfig.Polling.Mode
+	if pollingMode == "" {
+		pollingMode = pollingFallback
+	}
+	if pollingMode != pollingFallback && pollingMode != pollingAlways && pollingMode != pollingDisabled {
+		return nil, fmt.Errorf("GetBackupScheduler: unknown change_polling mode %q", pollingMode)
+	}
+
+	subscriptions, err := dialEventSubscriptions(vault, appConfig)
+	if err != nil {
+		if pollingMode != pollingFallback || len(appConfig.Polling.Mounts) == 0 {
+			return nil, err
+		}
+		log.Printf("Vault events unavailable, falling back to polling: %v", err)
+	}
+
+	var poller *ChangePoller
+	if pollingMode == pollingAlways || (pollingMode == pollingFallback && subscriptions == nil) {
+		if poller, err = newChangePoller(vault, appConfig.Polling); err != nil {
+			return nil, err
+		}
+	}
 
-	wsHeader := http.Header{"X-Vault-Token": []string{token.Auth.ClientToken}}
-	wsDialer := websocket.DefaultDialer
+	backupMode := appConfig.BackupMode
+	if backupMode == "" {
+		backupMode = backupModeRaft
+	}
+	switch backupMode {
+	case backupModeRaft:
+	case backupModeLogical:
+		if len(appConfig.LogicalBackup.Mounts) == 0 {
+			return nil, fmt.Errorf("GetBackupScheduler: logical backup mode needs logical_backup.mounts")
+		}
+		if err := appConfig.LogicalBackup.EncryptionKey.Validate(); err != nil {
+			return nil, fmt.Errorf("GetBackupScheduler: logical_backup.encryption_key %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("GetBackupScheduler: unknown backup_mode %q", backupMode)
+	}
 
-	conn, _, err := wsDialer.Dial(wsURL, wsHeader)
+	filter, err := newEventFilter(appConfig.Events.IncludePaths, appConfig.Events.ExcludePaths)
 	if err != nil {
 		return nil, err
 	}
 
-	return &BackupScheduler{
-			vault:             vault,
-			appConfig:         appConfig,
-			googleDriveClient: googleDriveClient,
-			wsConnection:      conn,
-			scheduler:         gocron.NewScheduler(time.UTC),
-			notifier:          emailNotifier,
-		},
-		nil
+	digest, err := GetDigest(appConfig.AppName, appConfig.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	preCheckRetryInterval, preCheckMaxWait := defaultPreCheckRetryInterval, defaultPreCheckMaxWait
+	if appConfig.SnapshotPreCheck.RetryInterval != "" {
+		preCheckRetryInterval, err = time.ParseDuration(appConfig.SnapshotPreCheck.RetryInterval)
+		if err != nil || preCheckRetryInterval <= 0 {
+			return nil, fmt.Errorf("GetBackupScheduler: invalid snapshot_precheck.retry_interval %q", appConfig.SnapshotPreCheck.RetryInterval)
+		}
+	}
+	if appConfig.SnapshotPreCheck.MaxWait != "" {
+		preCheckMaxWait, err = time.ParseDuration(appConfig.SnapshotPreCheck.MaxWait)
+		if err != nil || preCheckMaxWait < 0 {
+			return nil, fmt.Errorf("GetBackupScheduler: invalid snapshot_precheck.max_wait %q", appConfig.SnapshotPreCheck.MaxWait)
+		}
+	}
+
+	bs := &BackupScheduler{
+		vault:             vault,
+		appConfig:         appConfig,
+		googleDriveClient: googleDriveClient,
+		subscriptions:     subscriptions,
+		eventFilter:       filter,
+		poller:            poller,
+		scheduler:         gocron.NewScheduler(time.UTC),
+		notifier:          notifier,
+		jobs:              GetJobRegistry(appConfig.StatusServer.JobHistoryLimit),
+		progress:          GetProgressHub(),
+		retention:         &retentionTracker{},
+		appStatus:         appStatus,
+		heartbeat:         heartbeat,
+		digest:            digest,
+		events:            make(chan BackupType, 10),
+
+		preCheckRetryInterval: preCheckRetryInterval,
+		preCheckMaxWait:       preCheckMaxWait,
+		backupMode:            backupMode,
+	}
+
+	bs.coalescer, err = newEventCoalescer(appConfig.Events, func(events []VaultEvent) {
+		bs.triggerEventBackup(events)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bs, nil
 }
 
-func (bs BackupScheduler) vaultEventListener(events chan BackupType) {
-	log.Println("Connected to vault events. Listening...")
-	for {
-		_, _, err := bs.wsConnection.ReadMessage()
+func (bs BackupScheduler) onPolledChanges(events []VaultEvent) {
+	for _, event := range events {
+		if !bs.eventFilter.match(event) {
+			continue
+		}
+		log.Printf("ChangePoller: %s of %s", event.Operation, event.Path)
+		bs.coalescer.add(&event)
+	}
+}
+
+// triggerEventBackup queues one backup for a coalesced burst of events.
+func (bs BackupScheduler) triggerEventBackup(events []VaultEvent) {
+	if len(events) > 1 {
+		log.Printf("vaultEventListener: %d changes coalesced into one backup", len(events))
+	}
+	if _, err := bs.TriggerBackup(WssEvent, bs.appConfig.GoogleDriveConfig.OnEventDeployFolderId, events...); err != nil {
+		log.Printf("vaultEventListener: %v", err)
+	}
+}
+
+// dialEventSubscriptions opens one events websocket per configured event
+// type, closing the ones already open if any of them fails.
+func dialEventSubscriptions(vault *Vault, appConfig *config.AppConfig) ([]*EventSubscription, error) {
+	eventTypes := appConfig.Events.Types
+	if len(eventTypes) == 0 {
+		eventTypes = []string{appConfig.VaultConfig.ListenedEventsType}
+	}
+
+	var subscriptions []*EventSubscription
+	for _, eventType := range eventTypes {
+		subscription, err := newEventSubscription(vault, eventsSubscribeURL(appConfig, eventType), appConfig.Events)
+		if err == nil {
+			err = subscription.dial()
+		}
 		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
-			break
+			for _, s := range subscriptions {
+				s.Close()
+			}
+			return nil, err
 		}
-		eventType := BackupType{WssEvent, bs.appConfig.GoogleDriveConfig.OnEventDeployFolderId}
-		events <- eventType
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// eventsSubscribeURL builds the websocket URL for one event type, passing the
+// optional filter expression on to Vault.
+func eventsSubscribeURL(appConfig *config.AppConfig, eventType string) string {
+	wsURL := fmt.Sprintf("%s/%s/%s?json=true",
+		appConfig.VaultConfig.WebSocketEventBaseUrl,
+		vaultWebsocketPath,
+		eventType)
+	if appConfig.Events.Filter != "" {
+		wsURL += "&filter=" + url.QueryEscape(appConfig.Events.Filter)
 	}
+	return wsURL
 }
 
-func (bs BackupScheduler) scheduledTimeBackup(events chan BackupType) {
-	_, err := bs.scheduler.Every(bs.appConfig.VaultConfig.ScheduledSnapshotInterval).Do(func() {
+// TriggerBackup queues a backup job uploading to the given Drive folder and
+// returns it without waiting for the backup to run. The Vault events that
+// caused it, if any, are recorded on the job and in the manifest.
+func (bs BackupScheduler) TriggerBackup(eventType Event, gDriveFolderId string, events ...VaultEvent) (BackupJob, error) {
+	job := bs.jobs.NewJob(eventType, gDriveFolderId, events...)
+
+	select {
+	case bs.events <- BackupType{eventType, gDriveFolderId, job.Id, events}:
+		return job, nil
+	default:
+		bs.jobs.Finish(job.Id, BackupResult{}, fmt.Errorf("backup queue is full"))
+		return job, fmt.Errorf("TriggerBackup: backup queue is full, %s dropped", eventType)
+	}
+}
+
+func (bs BackupScheduler) Jobs() *JobRegistry {
+	return bs.jobs
+}
+
+func (bs BackupScheduler) Progress() *ProgressHub {
+	return bs.progress
+}
+
+func (bs BackupScheduler) vaultEventListener(ctx context.Context, subscription *EventSubscription) {
+	log.Println("Connected to vault events. Listening...")
+	disconnectNotified := false
+	subscription.Run(ctx, EventHandlers{
+		OnMessage: func(message []byte) {
+			event, err := parseVaultEvent(message)
+			if err != nil {
+				// back up anyway rather than miss a change
+				log.Printf("vaultEventListener: %v", err)
+				bs.coalescer.add(nil)
+				return
+			}
+			if !bs.eventFilter.match(event) {
+				log.Printf("vaultEventListener: ignoring %s on %s", event.Type, event.Path)
+				return
+			}
+			log.Printf("vaultEventListener: %s on %s", event.Type, event.Path)
+			bs.coalescer.add(&event)
+		},
+		OnDisconnect: func(err error, downtime time.Duration) {
+			disconnectNotified = true
+			SendNotification(bs.notifier, Notification{
+				Event:         WebsocketDisconnectedEvent,
+				Severity:      SeverityWarning,
+				Error:         err.Error(),
+				Downtime:      downtime,
+				CatchUpBackup: bs.appConfig.Events.CatchUpBackup,
+			})
+		},
+		OnReconnect: func(downtime time.Duration) {
+			// a reconnect is only news to those told about the disconnect
+			if disconnectNotified {
+				disconnectNotified = false
+				SendNotification(bs.notifier, Notification{
+					Event:         WebsocketReconnectedEvent,
+					Severity:      SeverityInfo,
+					Downtime:      downtime.Round(time.Second),
+					CatchUpBackup: bs.appConfig.Events.CatchUpBackup,
+				})
+			}
+			if !bs.appConfig.Events.CatchUpBackup {
+				return
+			}
+			log.Println("Performing catch-up backup after reconnect...")
+			if _, err := bs.TriggerBackup(WssEvent, bs.appConfig.GoogleDriveConfig.OnEventDeployFolderId); err != nil {
+				log.Printf("vaultEventListener: %v", err)
+			}
+		},
+	})
+}
+
+func (bs BackupScheduler) scheduledTimeBackup() {
+	_, err := bs.scheduler.Every(bs.appConfig.VaultConfig.ScheduledSnapshotInterval).Tag(scheduledBackupTag).Do(func() {
 		log.Println("Performing scheduled backup...")
-		events <- BackupType{ScheduledEvent, bs.appConfig.GoogleDriveConfig.ScheduledDeployFolderId}
+		if _, err := bs.TriggerBackup(ScheduledEvent, bs.appConfig.GoogleDriveConfig.ScheduledDeployFolderId); err != nil {
+			log.Printf("scheduledTimeBackup: %v", err)
+		}
 	})
 
 	if err != nil {
@@ -104,11 +327,31 @@ func (bs BackupScheduler) scheduledTimeBackup(events chan BackupType) {
 }
 
 func (bs BackupScheduler) scheduledTimeBackupCleanup() {
-	_, err := bs.scheduler.Every(bs.appConfig.VaultConfig.ScheduledSnapshotInterval).Do(func() error {
-		deletedFilesNumber, err := bs.googleDriveClient.RemoveOutdatedBackups()
+	_, err := bs.scheduler.Every(bs.appConfig.VaultConfig.ScheduledSnapshotInterval).Tag(retentionCleanupTag).Do(func() error {
+		deletedFilesNumber, err := bs.googleDriveClient.RemoveOutdatedBackups(func(f drive.File) {
+			bs.progress.Publish(ProgressEvent{Type: RetentionDeleted, FileName: f.Name, FileId: f.Id})
+		})
+		bs.retention.record(deletedFilesNumber, err)
+		bs.digest.RecordRetention(deletedFilesNumber, err)
 		if err != nil {
+			SendNotification(bs.notifier, Notification{
+				Event:         RetentionFailedEvent,
+				Severity:      SeverityWarning,
+				Destination:   googleDriveDestination,
+				Error:         err.Error(),
+				RetentionDays: bs.appConfig.GoogleDriveConfig.BackupFileRetentionDays,
+			})
 			return fmt.Errorf("scheduledTimeBackupCleanup: error when removinig outdated backups %w", err)
 		}
+		if deletedFilesNumber > 0 {
+			SendNotification(bs.notifier, Notification{
+				Event:         RetentionDeletedEvent,
+				Severity:      SeverityInfo,
+				Destination:   googleDriveDestination,
+				Count:         deletedFilesNumber,
+				RetentionDays: bs.appConfig.GoogleDriveConfig.BackupFileRetentionDays,
+			})
+		}
 		log.Printf("scheduledTimeBackupCleanup: successfully deleted %d backup files\n", deletedFilesNumber)
 		return nil
 	})
@@ -118,42 +361,274 @@ func (bs BackupScheduler) scheduledTimeBackupCleanup() {
 	}
 }
 
-func (bs BackupScheduler) onEventBackup(events chan BackupType) {
+func (bs BackupScheduler) onEventBackup() {
 	for {
 		select {
-		case e := <-events:
-			nowTimestamp := time.Now().Unix()
+		case e := <-bs.events:
+			ctx, ok := bs.jobs.Start(e.jobId)
+			if !ok {
+				log.Printf("onEventBackup: job %s was cancelled before it started", e.jobId)
+				continue
+			}
 
-			log.Printf("Event %s recived. Performing backup...", e.eventType)
-			filePath := filepath.Join(bs.appConfig.VaultConfig.SnapshotFolder, fmt.Sprintf("%d.snap", nowTimestamp))
-			backupFile, _ := bs.vault.RaftSnapshot(filePath)
-			log.Printf("Backup %s created succesfully \n", backupFile.Name())
+			startedAt := time.Now()
+			bs.heartbeat.Start(e.jobId, e.eventType)
+			result, err := bs.performBackup(ctx, e)
+			// Finish cancels the job context, check for a user cancellation first
+			cancelled := err != nil && ctx.Err() != nil
+			bs.jobs.Finish(e.jobId, result, err)
+			bs.publishJobCompleted(e.jobId)
+			if job, ok := bs.jobs.Get(e.jobId); ok {
+				bs.digest.RecordJob(job)
+			}
+			if cancelled {
+				// an operator action, neither a failure nor a backup to report
+				log.Printf("onEventBackup: job %s cancelled: %v \n", e.jobId, err)
+				continue
+			}
 
-			fileId, err := bs.googleDriveClient.DeployBackupToGoogleDrive(filePath, e.gDriveFileId)
+			bs.heartbeat.Finish(e.jobId, e.eventType, time.Since(startedAt), err)
+			bs.appStatus.RecordBackup(e.eventType.String(), err)
 			if err != nil {
-				backupErrorEmailSubject := fmt.Sprintf("%s error while uploading backup", bs.appConfig.AppName)
-				backupErrorEmailMessage := fmt.Sprintf("Hello \n This email was sent from %s. "+
-					"There was an error while uploading backup to Google Drive: %s", bs.appConfig.AppName, err)
-
-				log.Printf("onEventBackup: error while uploading backup to Google Drive %v \n", err)
-				log.Printf("onEventBackup: noify by email \n")
-				SendNotification(bs.notifier, backupErrorEmailSubject, backupErrorEmailMessage)
+				log.Printf("onEventBackup: %v \n", err)
+				log.Printf("onEventBackup: sending failure notification \n")
+				SendNotification(bs.notifier, Notification{
+					Event:       BackupFailedEvent,
+					Severity:    SeverityCritical,
+					JobId:       e.jobId,
+					Trigger:     e.eventType.String(),
+					Destination: fmt.Sprintf("%s:%s", googleDriveDestination, e.gDriveFileId),
+					Error:       err.Error(),
+					StartedAt:   startedAt,
+					FinishedAt:  time.Now(),
+				})
 			} else {
-				log.Printf("New file id: %d\n", fileId)
+				log.Printf("New file id: %s\n", result.RemoteFileId)
+				SendNotification(bs.notifier, Notification{
+					Event:       BackupSucceededEvent,
+					Severity:    SeverityInfo,
+					JobId:       e.jobId,
+					Trigger:     e.eventType.String(),
+					Destination: fmt.Sprintf("%s:%s", googleDriveDestination, e.gDriveFileId),
+					StartedAt:   startedAt,
+					FinishedAt:  time.Now(),
+					FileName:    result.FileName,
+					FileId:      result.RemoteFileId,
+					Size:        result.Size,
+				})
 			}
 		}
 	}
 }
 
+func (bs BackupScheduler) performBackup(ctx context.Context, e BackupType) (BackupResult, error) {
+	nowTimestamp := time.Now().Unix()
+	result := BackupResult{}
+
+	log.Printf("Event %s recived. Performing backup...", e.eventType)
+	extension := "snap"
+	if bs.backupMode == backupModeLogical {
+		extension = kvArchiveExtension
+	}
+	filePath := filepath.Join(bs.appConfig.VaultConfig.SnapshotFolder, fmt.Sprintf("%d.%s", nowTimestamp, extension))
+	fileName := filepath.Base(filePath)
+
+	var source *SnapshotSource
+	if bs.backupMode == backupModeLogical {
+		bs.progress.Publish(ProgressEvent{Type: SnapshotStarted, JobId: e.jobId, FileName: fileName})
+		if err := bs.createLogicalBackup(ctx, filePath); err != nil {
+			return result, fmt.Errorf("performBackup: error while creating logical backup %w", err)
+		}
+	} else {
+		var err error
+		if source, err = bs.waitForSnapshotSource(ctx); err != nil {
+			return result, fmt.Errorf("performBackup: %w", err)
+		}
+
+		bs.progress.Publish(ProgressEvent{Type: SnapshotStarted, JobId: e.jobId, FileName: fileName})
+		_, err = bs.vault.RaftSnapshot(ctx, source, filePath, func(written int64) {
+			bs.progress.Publish(ProgressEvent{Type: SnapshotProgress, JobId: e.jobId, FileName: fileName, Bytes: written})
+		})
+		if err != nil {
+			return result, fmt.Errorf("performBackup: error while creating snapshot %w", err)
+		}
+	}
+	log.Printf("Backup %s created succesfully \n", filePath)
+	result.FileName = filePath
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return result, fmt.Errorf("performBackup: unable to stat snapshot %w", err)
+	}
+	result.Size = info.Size()
+	bs.progress.Publish(ProgressEvent{Type: SnapshotCompleted, JobId: e.jobId, FileName: fileName, Bytes: result.Size})
+
+	fileId, err := bs.googleDriveClient.DeployBackupToGoogleDrive(ctx, filePath, e.gDriveFileId, func(current, total int64) {
+		bs.progress.Publish(ProgressEvent{Type: UploadProgress, JobId: e.jobId, FileName: fileName, Bytes: current, TotalBytes: total})
+	})
+	if err != nil {
+		return result, fmt.Errorf("performBackup: error while uploading backup to Google Drive %w", err)
+	}
+	result.RemoteFileId = *fileId
+	bs.progress.Publish(ProgressEvent{Type: UploadCompleted, JobId: e.jobId, FileName: fileName, FileId: *fileId})
+
+	if err := bs.verifyBackup(ctx, e.jobId, result.RemoteFileId, filePath); err != nil {
+		return result, fmt.Errorf("performBackup: uploaded backup failed verification %w", err)
+	}
+
+	// the snapshot is safe at this point, a missing manifest only loses context
+	manifestFileId, err := bs.uploadManifest(ctx, e, source, filePath, result.Size)
+	if err != nil {
+		log.Printf("performBackup: %v", err)
+	}
+	result.ManifestFileId = manifestFileId
+
+	return result, nil
+}
+
+// createLogicalBackup exports the configured KV v2 mounts into an encrypted
+// archive at filePath. The key is read on every backup so a rotated key is
+// picked up without a restart.
+func (bs BackupScheduler) createLogicalBackup(ctx context.Context, filePath string) error {
+	logical := bs.appConfig.LogicalBackup
+	key, err := bs.vault.GetKVArchiveKey(ctx, logical.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	archive, err := bs.vault.ExportKVv2(ctx, logical.Mounts, logical.AllVersions)
+	if err != nil {
+		return err
+	}
+	archive.AppName = bs.appConfig.AppName
+	archive.Cluster = bs.appConfig.VaultConfig.ClusterName
+	log.Printf("createLogicalBackup: exported %d secrets from %s", archive.secretCount(), strings.Join(logical.Mounts, ", "))
+
+	return writeKVArchive(filePath, archive, key)
+}
+
+// waitForSnapshotSource runs the snapshot pre-checks, retrying while they
+// report a transient condition such as a sealed node or an unhealthy raft
+// cluster, for at most max_wait. It returns nil when pre-checks are disabled.
+func (bs BackupScheduler) waitForSnapshotSource(ctx context.Context) (*SnapshotSource, error) {
+	precheck := bs.appConfig.SnapshotPreCheck
+	if !precheck.Enabled {
+		return nil, nil
+	}
+
+	retryInterval := bs.preCheckRetryInterval
+	deadline := time.Now().Add(bs.preCheckMaxWait)
+	for {
+		source, err := bs.vault.SnapshotPreCheck(ctx, precheck.RequireHealthyAutopilot)
+		if err == nil {
+			return source, nil
+		}
+		if !errors.Is(err, errSnapshotDeferred) || time.Now().Add(retryInterval).After(deadline) {
+			return nil, fmt.Errorf("snapshot pre-check failed %w", err)
+		}
+
+		log.Printf("Snapshot pre-check: %v, retrying in %s", err, retryInterval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// uploadManifest writes the manifest next to the snapshot and uploads it to
+// the same Drive folder.
+func (bs BackupScheduler) uploadManifest(ctx context.Context, e BackupType, source *SnapshotSource, snapshotPath string, size int64) (string, error) {
+	manifestPath := strings.TrimSuffix(snapshotPath, filepath.Ext(snapshotPath)) + ".manifest.json"
+	var node *NodeInfo
+	if source != nil {
+		node = &source.Node
+	}
+	err := writeManifest(manifestPath, BackupManifest{
+		Version:      manifestVersion,
+		AppName:      bs.appConfig.AppName,
+		Cluster:      bs.appConfig.VaultConfig.ClusterName,
+		JobId:        e.jobId,
+		Trigger:      e.eventType.String(),
+		Mode:         bs.backupMode,
+		CreatedAt:    time.Now(),
+		SnapshotFile: filepath.Base(snapshotPath),
+		SnapshotSize: size,
+		Events:       e.events,
+		Node:         node,
+	})
+	if err != nil {
+		return "", fmt.Errorf("uploadManifest: %w", err)
+	}
+
+	fileId, err := bs.googleDriveClient.DeployBackupToGoogleDrive(ctx, manifestPath, e.gDriveFileId, nil)
+	if err != nil {
+		return "", fmt.Errorf("uploadManifest: unable to upload manifest %w", err)
+	}
+	return *fileId, nil
+}
+
+// verifyBackup checks the uploaded file against the local snapshot and
+// publishes the outcome as a progress event.
+func (bs BackupScheduler) verifyBackup(ctx context.Context, jobId, fileId, filePath string) error {
+	err := bs.googleDriveClient.VerifyBackup(ctx, fileId, filePath)
+
+	verified := ProgressEvent{Type: BackupVerified, JobId: jobId, FileName: filepath.Base(filePath), FileId: fileId, Status: "ok"}
+	if err != nil {
+		verified.Status = "failed"
+		verified.Error = err.Error()
+	}
+	bs.progress.Publish(verified)
+
+	return err
+}
+
+// VerifyLatestBackup re-verifies the most recent successful backup against
+// its local snapshot file.
+func (bs BackupScheduler) VerifyLatestBackup(ctx context.Context) (BackupJob, error) {
+	job, ok := bs.jobs.LatestSucceeded("")
+	if !ok {
+		return job, fmt.Errorf("VerifyLatestBackup: no successful backup to verify")
+	}
+
+	if err := bs.verifyBackup(ctx, job.Id, job.RemoteFileId, job.FileName); err != nil {
+		return job, fmt.Errorf("VerifyLatestBackup: %w", err)
+	}
+	return job, nil
+}
+
+func (bs BackupScheduler) publishJobCompleted(jobId string) {
+	job, ok := bs.jobs.Get(jobId)
+	if !ok {
+		return
+	}
+	bs.progress.Publish(ProgressEvent{
+		Type:     JobCompleted,
+		JobId:    job.Id,
+		FileName: job.FileName,
+		FileId:   job.RemoteFileId,
+		Status:   string(job.Status),
+		Error:    job.Error,
+	})
+}
+
 func (bs BackupScheduler) CreateVaultBackups() {
-	defer bs.wsConnection.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	events := make(chan BackupType, 10)
-	go bs.vaultEventListener(events)
-	go bs.onEventBackup(events)
-	go bs.scheduledTimeBackup(events)
+	for _, subscription := range bs.subscriptions {
+		go bs.vaultEventListener(ctx, subscription)
+	}
+	if bs.poller != nil {
+		go bs.poller.Run(ctx, bs.onPolledChanges)
+	}
+	go bs.onEventBackup()
+	go bs.scheduledTimeBackup()
 
 	bs.scheduledTimeBackupCleanup()
+	if err := bs.digest.schedule(bs.scheduler, bs.notifier); err != nil {
+		log.Printf("CreateVaultBackups: %v", err)
+	}
 	bs.scheduler.StartBlocking()
 
 	interrupt := make(chan os.Signal, 1)
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+type BackupStatus struct {
+	AppName              string              `json:"app_name"`
+	Jobs                 []BackupJob         `json:"jobs"`
+	Destinations         []DestinationStatus `json:"destinations"`
+	Retention            RetentionState      `json:"retention"`
+	NextScheduledBackup  *time.Time          `json:"next_scheduled_backup,omitempty"`
+	NextRetentionCleanup *time.Time          `json:"next_retention_cleanup,omitempty"`
+	VaultToken           TokenState          `json:"vault_token"`
+}
+
+type DestinationStatus struct {
+	Destination string     `json:"destination"`
+	FolderId    string     `json:"folder_id"`
+	LastStatus  JobStatus  `json:"last_status,omitempty"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastSize    int64      `json:"last_size,omitempty"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+}
+
+type RetentionState struct {
+	RetentionDays int        `json:"retention_days"`
+	LastRun       *time.Time `json:"last_run,omitempty"`
+	LastDeleted   int        `json:"last_deleted"`
+	TotalDeleted  int        `json:"total_deleted"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+type retentionTracker struct {
+	mu    sync.Mutex
+	state RetentionState
+}
+
+func (t *retentionTracker) record(deleted int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.state.LastRun = &now
+	t.state.LastDeleted = deleted
+	t.state.TotalDeleted += deleted
+	t.state.LastError = ""
+	if err != nil {
+		t.state.LastError = err.Error()
+	}
+}
+
+func (t *retentionTracker) get() RetentionState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Status summarises job history per destination together with retention
+// and scheduling state for the dashboard.
+func (bs BackupScheduler) Status() BackupStatus {
+	status := BackupStatus{
+		AppName:    bs.appConfig.AppName,
+		Jobs:       bs.jobs.List(),
+		Retention:  bs.retention.get(),
+		VaultToken: bs.vault.Tokens().State(),
+	}
+	status.Retention.RetentionDays = bs.appConfig.GoogleDriveConfig.BackupFileRetentionDays
+	status.NextScheduledBackup = bs.nextRun(scheduledBackupTag)
+	status.NextRetentionCleanup = bs.nextRun(retentionCleanupTag)
+
+	folders := []string{
+		bs.appConfig.GoogleDriveConfig.OnEventDeployFolderId,
+		bs.appConfig.GoogleDriveConfig.ScheduledDeployFolderId,
+	}
+	for _, job := range status.Jobs {
+		folders = append(folders, job.FolderId)
+	}
+
+	seen := make(map[string]bool)
+	for _, folderId := range folders {
+		if seen[folderId] {
+			continue
+		}
+		seen[folderId] = true
+		status.Destinations = append(status.Destinations, destinationStatus(folderId, status.Jobs))
+	}
+
+	return status
+}
+
+// destinationStatus aggregates finished jobs for a folder, jobs are expected
+// newest first so the first match is the latest run.
+func destinationStatus(folderId string, jobs []BackupJob) DestinationStatus {
+	d := DestinationStatus{Destination: googleDriveDestination, FolderId: folderId}
+
+	for _, job := range jobs {
+		if job.FolderId != folderId || job.FinishedAt == nil {
+			continue
+		}
+		if d.LastRun == nil {
+			d.LastStatus = job.Status
+			d.LastRun = job.FinishedAt
+		}
+		switch job.Status {
+		case JobSucceeded:
+			d.Succeeded++
+			if d.LastSuccess == nil {
+				d.LastSuccess = job.FinishedAt
+				d.LastSize = job.Size
+			}
+		case JobFailed:
+			d.Failed++
+		}
+	}
+	return d
+}
+
+func (bs BackupScheduler) nextRun(tag string) *time.Time {
+	jobs, err := bs.scheduler.FindJobsByTag(tag)
+	if err != nil || len(jobs) == 0 {
+		return nil
+	}
+	next := jobs[0].NextRun()
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
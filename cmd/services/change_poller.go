@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	pollingDisabled = "disabled"
+	pollingFallback = "fallback"
+	pollingAlways   = "always"
+
+	defaultPollingInterval = 5 * time.Minute
+	polledEventType        = "poll/kv-v2"
+)
+
+// ChangePoller detects KV v2 changes without the events API by comparing
+// every secret's current version and update time with the previous poll.
+// The last seen state is persisted so changes made while the daemon was
+// down are noticed after a restart.
+type ChangePoller struct {
+	vault     *Vault
+	mounts    []string
+	interval  time.Duration
+	statePath string
+	// mount -> secret path -> "version@updated_time"
+	state map[string]map[string]string
+}
+
+func newChangePoller(vault *Vault, pollingConfig config.PollingConfig) (*ChangePoller, error) {
+	if len(pollingConfig.Mounts) == 0 {
+		return nil, fmt.Errorf("newChangePoller: no KV v2 mounts configured")
+	}
+
+	p := &ChangePoller{
+		vault:     vault,
+		mounts:    pollingConfig.Mounts,
+		interval:  defaultPollingInterval,
+		statePath: pollingConfig.StateFile,
+	}
+	if pollingConfig.Interval != "" {
+		interval, err := time.ParseDuration(pollingConfig.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("newChangePoller: invalid interval %q", pollingConfig.Interval)
+		}
+		p.interval = interval
+	}
+
+	if err := p.load(); err != nil {
+		return nil, fmt.Errorf("newChangePoller: %w", err)
+	}
+	return p, nil
+}
+
+// Run polls until ctx is done and reports the changes of every poll. The
+// first poll without a saved state only records the baseline.
+func (p *ChangePoller) Run(ctx context.Context, onChange func(events []VaultEvent)) {
+	log.Printf("Polling %s for changes every %s", strings.Join(p.mounts, ", "), p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		events, err := p.poll(ctx)
+		if err != nil {
+			log.Printf("ChangePoller: %v", err)
+		} else if len(events) > 0 {
+			onChange(events)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *ChangePoller) poll(ctx context.Context) ([]VaultEvent, error) {
+	current := make(map[string]map[string]string)
+	for _, mount := range p.mounts {
+		mount = strings.Trim(mount, "/")
+		secrets := make(map[string]string)
+		err := p.vault.WalkKVv2(ctx, mount, func(secretPath string) error {
+			metadata, err := p.vault.GetKVv2Metadata(ctx, mount, secretPath)
+			if err != nil {
+				return err
+			}
+			secrets[secretPath] = fmt.Sprintf("%d@%s",
+				metadata.CurrentVersion, metadata.UpdatedTime.UTC().Format(time.RFC3339Nano))
+			return nil
+		})
+		if err != nil {
+			// keep the previous state, a partial walk would report deletions
+			return nil, fmt.Errorf("poll: %w", err)
+		}
+		current[mount] = secrets
+	}
+
+	baseline := p.state == nil
+	previous := p.state
+	p.state = current
+	if err := p.save(); err != nil {
+		log.Printf("ChangePoller: %v", err)
+	}
+	if baseline {
+		log.Printf("ChangePoller: recorded baseline of %s", strings.Join(p.mounts, ", "))
+		return nil, nil
+	}
+
+	now := time.Now()
+	var events []VaultEvent
+	for mount, secrets := range current {
+		// a mount added to the configuration starts with its baseline
+		old, known := previous[mount]
+		if !known {
+			continue
+		}
+		for secretPath, version := range secrets {
+			switch oldVersion, ok := old[secretPath]; {
+			case !ok:
+				events = append(events, polledEvent(mount, secretPath, "create", version, now))
+			case oldVersion != version:
+				events = append(events, polledEvent(mount, secretPath, "update", version, now))
+			}
+		}
+		for secretPath := range old {
+			if _, ok := secrets[secretPath]; !ok {
+				events = append(events, polledEvent(mount, secretPath, "delete", "", now))
+			}
+		}
+	}
+	return events, nil
+}
+
+// polledEvent describes a change found by polling. The path uses the
+// mount/data/secret form of kv-v2 events so path filters apply to both.
+func polledEvent(mount, secretPath, operation, version string, at time.Time) VaultEvent {
+	event := VaultEvent{
+		Type:      polledEventType,
+		Operation: operation,
+		Path:      mount + "/data/" + secretPath,
+		Mount:     mount + "/",
+		Modified:  true,
+		Time:      at,
+	}
+	if currentVersion, _, ok := strings.Cut(version, "@"); ok {
+		event.Metadata = map[string]string{"current_version": currentVersion}
+	}
+	return event
+}
+
+func (p *ChangePoller) load() error {
+	if p.statePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read %s %w", p.statePath, err)
+	}
+	if err := json.Unmarshal(data, &p.state); err != nil {
+		return fmt.Errorf("unable to decode %s %w", p.statePath, err)
+	}
+	return nil
+}
+
+func (p *ChangePoller) save() error {
+	if p.statePath == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(p.state)
+	if err != nil {
+		return fmt.Errorf("save: error while encoding state %w", err)
+	}
+	tmpPath := p.statePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("save: error while writing %s %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, p.statePath); err != nil {
+		return fmt.Errorf("save: error while replacing %s %w", p.statePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		log.Fatalf("dashboardHandler: embedded dashboard is missing %v", err)
+	}
+	return http.FileServer(http.FS(files))
+}
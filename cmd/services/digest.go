@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"github.com/go-co-op/gocron"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	dailyDigest       = "daily"
+	weeklyDigest      = "weekly"
+	defaultDigestAt   = "08:00"
+	digestTag         = "notification-digest"
+	digestMaxFailures = 20
+)
+
+// Digest accumulates job and retention activity between two digest
+// notifications.
+type Digest struct {
+	mu               sync.Mutex
+	appName          string
+	interval         string
+	at               string
+	since            time.Time
+	jobsByStatus     map[JobStatus]int
+	jobsByTrigger    map[string]int
+	totalBytes       int64
+	largestBackup    int64
+	retentionRuns    int
+	retentionDeleted int
+	retentionErrors  int
+	failures         []string
+}
+
+func GetDigest(appName string, digestConfig config.DigestConfig) (*Digest, error) {
+	if digestConfig.Interval == "" {
+		return nil, nil
+	}
+	if digestConfig.Interval != dailyDigest && digestConfig.Interval != weeklyDigest {
+		return nil, fmt.Errorf("GetDigest: unknown interval %q, expected %s or %s", digestConfig.Interval, dailyDigest, weeklyDigest)
+	}
+
+	d := &Digest{appName: appName, interval: digestConfig.Interval, at: digestConfig.At}
+	if d.at == "" {
+		d.at = defaultDigestAt
+	}
+	d.reset()
+	return d, nil
+}
+
+func (d *Digest) RecordJob(job BackupJob) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.jobsByStatus[job.Status]++
+	d.jobsByTrigger[job.Trigger]++
+	if job.Status == JobSucceeded {
+		d.totalBytes += job.Size
+		d.largestBackup = max(d.largestBackup, job.Size)
+	}
+	if job.Status == JobFailed && len(d.failures) < digestMaxFailures {
+		d.failures = append(d.failures, fmt.Sprintf("%s %s: %s", job.QueuedAt.Format(time.RFC3339), job.Trigger, job.Error))
+	}
+}
+
+func (d *Digest) RecordRetention(deleted int, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.retentionRuns++
+	d.retentionDeleted += deleted
+	if err != nil {
+		d.retentionErrors++
+	}
+}
+
+func (d *Digest) schedule(scheduler *gocron.Scheduler, notifier Notifier) error {
+	if d == nil {
+		return nil
+	}
+
+	s := scheduler.Every(1)
+	if d.interval == weeklyDigest {
+		s = s.Week().Monday()
+	} else {
+		s = s.Day()
+	}
+
+	// the shared scheduler runs in UTC, so the configured time is UTC too
+	_, err := s.At(d.at).Tag(digestTag).Do(func() {
+		SendNotification(notifier, d.collect())
+	})
+	if err != nil {
+		return fmt.Errorf("schedule: error while scheduling %s digest %w", d.interval, err)
+	}
+	return nil
+}
+
+// collect builds the digest notification and starts a new period.
+func (d *Digest) collect() Notification {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	total := 0
+	for _, count := range d.jobsByStatus {
+		total += count
+	}
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "Backup activity since %s:\n\n", d.since.Format(time.RFC1123))
+	fmt.Fprintf(&b, "Jobs: %d (succeeded %d, failed %d, cancelled %d)\n",
+		total, d.jobsByStatus[JobSucceeded], d.jobsByStatus[JobFailed], d.jobsByStatus[JobCancelled])
+
+	triggers := make([]string, 0, len(d.jobsByTrigger))
+	for trigger := range d.jobsByTrigger {
+		triggers = append(triggers, trigger)
+	}
+	sort.Strings(triggers)
+	for _, trigger := range triggers {
+		fmt.Fprintf(&b, "  %s: %d\n", trigger, d.jobsByTrigger[trigger])
+	}
+
+	fmt.Fprintf(&b, "Uploaded: %d bytes, largest backup %d bytes\n", d.totalBytes, d.largestBackup)
+	fmt.Fprintf(&b, "Retention: %d runs, %d backups removed, %d errors\n", d.retentionRuns, d.retentionDeleted, d.retentionErrors)
+	if len(d.failures) > 0 {
+		fmt.Fprintf(&b, "\nFailures:\n%s\n", strings.Join(d.failures, "\n"))
+	}
+
+	severity := SeverityInfo
+	if d.jobsByStatus[JobFailed] > 0 || d.retentionErrors > 0 {
+		severity = SeverityWarning
+	}
+
+	n := Notification{
+		Event:    DigestEvent,
+		Severity: severity,
+		Subject:  fmt.Sprintf("%s %s backup digest: %d jobs, %d failed", d.appName, d.interval, total, d.jobsByStatus[JobFailed]),
+		Body:     b.String(),
+	}
+	d.reset()
+	return n
+}
+
+func (d *Digest) reset() {
+	d.since = time.Now()
+	d.jobsByStatus = make(map[JobStatus]int)
+	d.jobsByTrigger = make(map[string]int)
+	d.totalBytes = 0
+	d.largestBackup = 0
+	d.retentionRuns = 0
+	d.retentionDeleted = 0
+	d.retentionErrors = 0
+	d.failures = nil
+}
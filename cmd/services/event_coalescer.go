@@ -0,0 +1,116 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+// eventCoalescer turns bursts of change events into a single backup. A
+// backup fires once no event arrived for quietPeriod, at the latest maxDelay
+// after the first event of the burst, and never sooner than minInterval after
+// the previous one. With all durations zero events fire right away.
+type eventCoalescer struct {
+	quietPeriod time.Duration
+	maxDelay    time.Duration
+	minInterval time.Duration
+	fire        func(events []VaultEvent)
+
+	mu        sync.Mutex
+	pending   bool
+	events    []VaultEvent
+	firstAt   time.Time
+	lastAt    time.Time
+	lastFired time.Time
+	timer     *time.Timer
+}
+
+func newEventCoalescer(eventsConfig config.EventsConfig, fire func(events []VaultEvent)) (*eventCoalescer, error) {
+	c := &eventCoalescer{fire: fire}
+
+	durations := []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"debounce", eventsConfig.Debounce, &c.quietPeriod},
+		{"max_delay", eventsConfig.MaxDelay, &c.maxDelay},
+		{"min_interval", eventsConfig.MinInterval, &c.minInterval},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("newEventCoalescer: invalid %s %q", d.name, d.value)
+		}
+		*d.target = parsed
+	}
+
+	return c, nil
+}
+
+// add records a change, event is nil when the message could not be parsed.
+func (c *eventCoalescer) add(event *VaultEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if !c.pending {
+		c.pending = true
+		c.firstAt = now
+	}
+	c.lastAt = now
+	if event != nil {
+		c.record(*event)
+	}
+
+	c.schedule(now)
+}
+
+// record keeps the latest event per path and type.
+func (c *eventCoalescer) record(event VaultEvent) {
+	for i, e := range c.events {
+		if e.Path == event.Path && e.Type == event.Type {
+			c.events[i] = event
+			return
+		}
+	}
+	c.events = append(c.events, event)
+}
+
+func (c *eventCoalescer) schedule(now time.Time) {
+	due := c.lastAt.Add(c.quietPeriod)
+	if c.maxDelay > 0 {
+		if deadline := c.firstAt.Add(c.maxDelay); deadline.Before(due) {
+			due = deadline
+		}
+	}
+	if earliest := c.lastFired.Add(c.minInterval); due.Before(earliest) {
+		due = earliest
+	}
+
+	wait := due.Sub(now)
+	if c.timer == nil {
+		c.timer = time.AfterFunc(wait, c.flush)
+	} else {
+		c.timer.Reset(wait)
+	}
+}
+
+func (c *eventCoalescer) flush() {
+	c.mu.Lock()
+	if !c.pending {
+		c.mu.Unlock()
+		return
+	}
+	events := c.events
+	c.pending = false
+	c.events = nil
+	c.lastFired = time.Now()
+	c.mu.Unlock()
+
+	c.fire(events)
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+func newTestCoalescer(t *testing.T, eventsConfig config.EventsConfig) (*eventCoalescer, chan []VaultEvent) {
+	t.Helper()
+	fired := make(chan []VaultEvent, 10)
+	c, err := newEventCoalescer(eventsConfig, func(events []VaultEvent) { fired <- events })
+	if err != nil {
+		t.Fatalf("newEventCoalescer() = %v", err)
+	}
+	return c, fired
+}
+
+func TestEventCoalescerDebouncesBurst(t *testing.T) {
+	c, fired := newTestCoalescer(t, config.EventsConfig{Debounce: "50ms"})
+
+	start := time.Now()
+	for _, path := range []string{"secret/data/a", "secret/data/b", "secret/data/a"} {
+		c.add(&VaultEvent{Type: "kv-v2/data-write", Path: path})
+		time.Sleep(20 * time.Millisecond)
+	}
+	c.add(nil)
+	lastAdd := time.Now()
+
+	select {
+	case events := <-fired:
+		if elapsed := time.Since(lastAdd); elapsed < 40*time.Millisecond {
+			t.Errorf("fired %s after the last event, before the debounce window", elapsed)
+		}
+		if len(events) != 2 {
+			t.Errorf("got %d events, want one per path: %+v", len(events), events)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("burst started %s ago never fired", time.Since(start))
+	}
+
+	select {
+	case events := <-fired:
+		t.Errorf("burst fired twice, second time with %+v", events)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventCoalescerMaxDelay(t *testing.T) {
+	c, fired := newTestCoalescer(t, config.EventsConfig{Debounce: "50ms", MaxDelay: "100ms"})
+
+	start := time.Now()
+	done := time.After(300 * time.Millisecond)
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fired:
+			if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+				t.Errorf("fired after %s despite max_delay 100ms", elapsed)
+			}
+			return
+		case <-ticker.C:
+			c.add(&VaultEvent{Type: "kv-v2/data-write", Path: "secret/data/a"})
+		case <-done:
+			t.Fatal("a steady stream of events never fired")
+		}
+	}
+}
+
+func TestEventCoalescerRejectsInvalidDuration(t *testing.T) {
+	if _, err := newEventCoalescer(config.EventsConfig{Debounce: "soon"}, func([]VaultEvent) {}); err == nil {
+		t.Error("newEventCoalescer accepted an invalid debounce")
+	}
+}
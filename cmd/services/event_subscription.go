@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	defaultReconnectInitialBackoff = time.Second
+	defaultReconnectMaxBackoff     = 5 * time.Minute
+	defaultEventsPingInterval      = 30 * time.Second
+	defaultDisconnectAlertAfter    = 2 * time.Minute
+	eventsWriteTimeout             = 10 * time.Second
+)
+
+// EventSubscription keeps the Vault events websocket open, reconnecting with
+// exponential backoff and the current auth token whenever it drops.
+type EventSubscription struct {
+	vault                *Vault
+	url                  string
+	initialBackoff       time.Duration
+	maxBackoff           time.Duration
+	pingInterval         time.Duration
+	disconnectAlertAfter time.Duration
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+// EventHandlers are called from the subscription's goroutine. OnDisconnect
+// only runs once reconnecting has failed for disconnect_alert_after, a short
+// outage only results in OnReconnect.
+type EventHandlers struct {
+	OnMessage    func(message []byte)
+	OnDisconnect func(err error, downtime time.Duration)
+	OnReconnect  func(downtime time.Duration)
+}
+
+func newEventSubscription(vault *Vault, url string, eventsConfig config.EventsConfig) (*EventSubscription, error) {
+	s := &EventSubscription{
+		vault:                vault,
+		url:                  url,
+		initialBackoff:       defaultReconnectInitialBackoff,
+		maxBackoff:           defaultReconnectMaxBackoff,
+		pingInterval:         defaultEventsPingInterval,
+		disconnectAlertAfter: defaultDisconnectAlertAfter,
+	}
+
+	durations := []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"reconnect_initial_backoff", eventsConfig.ReconnectInitialBackoff, &s.initialBackoff},
+		{"reconnect_max_backoff", eventsConfig.ReconnectMaxBackoff, &s.maxBackoff},
+		{"ping_interval", eventsConfig.PingInterval, &s.pingInterval},
+		{"disconnect_alert_after", eventsConfig.DisconnectAlertAfter, &s.disconnectAlertAfter},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("newEventSubscription: invalid %s %q", d.name, d.value)
+		}
+		*d.target = parsed
+	}
+
+	return s, nil
+}
+
+// dial opens a new connection using the token currently held by the Vault
+// client and replaces the previous one.
+func (s *EventSubscription) dial() error {
+	dialer, header := s.vault.websocketDialer()
+	conn, _, err := dialer.Dial(s.url, header)
+	if err != nil {
+		return fmt.Errorf("unable to connect to Vault events %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(s.pongWait()))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(s.pongWait()))
+	})
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	return nil
+}
+
+// Run reads messages until ctx is done, reconnecting after read errors.
+func (s *EventSubscription) Run(ctx context.Context, handlers EventHandlers) {
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	for {
+		err := s.read(ctx, handlers.OnMessage)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("WebSocket read error: %v", err)
+
+		disconnectedAt := time.Now()
+		if !s.reconnect(ctx, err, handlers.OnDisconnect) {
+			return
+		}
+		log.Printf("Reconnected to vault events after %s", time.Since(disconnectedAt).Round(time.Second))
+		handlers.OnReconnect(time.Since(disconnectedAt))
+	}
+}
+
+func (s *EventSubscription) read(ctx context.Context, onMessage func([]byte)) error {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	defer conn.Close()
+
+	pingCtx, stopPing := context.WithCancel(ctx)
+	defer stopPing()
+	go s.ping(pingCtx, conn)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(s.pongWait()))
+		onMessage(message)
+	}
+}
+
+func (s *EventSubscription) ping(ctx context.Context, conn *websocket.Conn) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventsWriteTimeout)); err != nil {
+				log.Printf("WebSocket ping failed: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// reconnect dials until it succeeds, waiting an exponentially growing,
+// jittered delay between attempts. onDisconnect is called with the latest
+// error once reconnecting has not succeeded within disconnectAlertAfter.
+// It returns false once ctx is done.
+func (s *EventSubscription) reconnect(ctx context.Context, readErr error, onDisconnect func(error, time.Duration)) bool {
+	lastErr := readErr
+	alert := time.NewTimer(s.disconnectAlertAfter)
+	defer alert.Stop()
+
+	backoff := s.initialBackoff
+	for attempt := 1; ; attempt++ {
+		// jitter keeps several instances from reconnecting in lockstep
+		wait := time.NewTimer(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)))
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				wait.Stop()
+				return false
+			case <-alert.C:
+				onDisconnect(lastErr, s.disconnectAlertAfter)
+			case <-wait.C:
+				waiting = false
+			}
+		}
+
+		err := s.dial()
+		if err == nil {
+			return true
+		}
+		lastErr = err
+		log.Printf("WebSocket reconnect attempt %d failed: %v", attempt, err)
+		backoff = min(backoff*2, s.maxBackoff)
+	}
+}
+
+// pongWait is how long the connection may stay silent, a missed pong
+// surfaces as a read timeout.
+func (s *EventSubscription) pongWait() time.Duration {
+	return 2 * s.pingInterval
+}
+
+func (s *EventSubscription) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const defaultHeartbeatTimeout = 10 * time.Second
+
+// Heartbeat pings an external monitor around each backup job, so a dead
+// host is noticed even though it can no longer alert by itself.
+type Heartbeat struct {
+	client *http.Client
+	config config.HeartbeatConfig
+}
+
+func GetHeartbeat(heartbeatConfig config.HeartbeatConfig) (*Heartbeat, error) {
+	timeout := defaultHeartbeatTimeout
+	if heartbeatConfig.Timeout != "" {
+		t, err := time.ParseDuration(heartbeatConfig.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("GetHeartbeat: invalid timeout %w", err)
+		}
+		timeout = t
+	}
+
+	return &Heartbeat{
+		client: &http.Client{Timeout: timeout},
+		config: heartbeatConfig,
+	}, nil
+}
+
+func (h *Heartbeat) Start(jobId string, eventType Event) {
+	h.ping(h.config.StartUrl, fmt.Sprintf("job %s: %s backup started", jobId, eventType))
+}
+
+func (h *Heartbeat) Finish(jobId string, eventType Event, duration time.Duration, backupErr error) {
+	if backupErr != nil {
+		h.ping(h.config.FailUrl, fmt.Sprintf("job %s: %s backup failed after %s: %v",
+			jobId, eventType, duration.Round(time.Millisecond), backupErr))
+		return
+	}
+	h.ping(h.config.SuccessUrl, fmt.Sprintf("job %s: %s backup succeeded in %s",
+		jobId, eventType, duration.Round(time.Millisecond)))
+}
+
+func (h *Heartbeat) ping(url, body string) {
+	if url == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		log.Printf("Warning: heartbeat - invalid url %s %v", url, err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		log.Printf("Warning: heartbeat - error while pinging %s %v", url, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		log.Printf("Warning: heartbeat - %s responded with %s", url, res.Status)
+	}
+}
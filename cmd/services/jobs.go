@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultJobHistoryLimit = 50
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+type BackupJob struct {
+	Id           string     `json:"id"`
+	Trigger      string     `json:"trigger"`
+	FolderId     string     `json:"folder_id"`
+	Status       JobStatus  `json:"status"`
+	QueuedAt     time.Time  `json:"queued_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	FileName     string     `json:"file_name,omitempty"`
+	RemoteFileId string     `json:"remote_file_id,omitempty"`
+	Size         int64      `json:"size,omitempty"`
+	Error        string     `json:"error,omitempty"`
+
+	ManifestFileId string       `json:"manifest_file_id,omitempty"`
+	Events         []VaultEvent `json:"events,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+type BackupResult struct {
+	FileName       string
+	RemoteFileId   string
+	ManifestFileId string
+	Size           int64
+}
+
+type JobRegistry struct {
+	mu    sync.Mutex
+	jobs  []*BackupJob
+	limit int
+}
+
+func GetJobRegistry(limit int) *JobRegistry {
+	if limit <= 0 {
+		limit = defaultJobHistoryLimit
+	}
+	return &JobRegistry{limit: limit}
+}
+
+func (r *JobRegistry) NewJob(trigger Event, folderId string, events ...VaultEvent) BackupJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := &BackupJob{
+		Id:       newJobId(),
+		Trigger:  trigger.String(),
+		FolderId: folderId,
+		Status:   JobQueued,
+		QueuedAt: time.Now(),
+		Events:   events,
+	}
+	r.jobs = append(r.jobs, job)
+	r.trim()
+
+	return *job
+}
+
+// Start marks the job as running and returns a context cancelled by Cancel.
+// It returns false if the job was cancelled (or evicted) while still queued.
+func (r *JobRegistry) Start(id string) (context.Context, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.find(id)
+	if job == nil || job.Status != JobQueued {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	job.cancel = cancel
+
+	return ctx, true
+}
+
+func (r *JobRegistry) Finish(id string, result BackupResult, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.find(id)
+	if job == nil {
+		return
+	}
+
+	now := time.Now()
+	job.FinishedAt = &now
+	job.FileName = result.FileName
+	job.RemoteFileId = result.RemoteFileId
+	job.ManifestFileId = result.ManifestFileId
+	job.Size = result.Size
+
+	switch {
+	case job.Status == JobCancelled:
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobSucceeded
+	}
+
+	if job.cancel != nil {
+		job.cancel()
+		job.cancel = nil
+	}
+}
+
+func (r *JobRegistry) Cancel(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.find(id)
+	if job == nil {
+		return fmt.Errorf("Cancel: job %s not found", id)
+	}
+
+	switch job.Status {
+	case JobQueued:
+		now := time.Now()
+		job.FinishedAt = &now
+	case JobRunning:
+		job.cancel()
+	default:
+		return fmt.Errorf("Cancel: job %s is already %s", id, job.Status)
+	}
+	job.Status = JobCancelled
+
+	return nil
+}
+
+func (r *JobRegistry) List() []BackupJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	jobs := make([]BackupJob, 0, len(r.jobs))
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		jobs = append(jobs, *r.jobs[i])
+	}
+	return jobs
+}
+
+func (r *JobRegistry) Get(id string) (BackupJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.find(id)
+	if job == nil {
+		return BackupJob{}, false
+	}
+	return *job, true
+}
+
+// LatestSucceeded returns the most recent successful job, optionally
+// limited to a single Drive folder.
+func (r *JobRegistry) LatestSucceeded(folderId string) (BackupJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := len(r.jobs) - 1; i >= 0; i-- {
+		job := r.jobs[i]
+		if job.Status == JobSucceeded && (folderId == "" || job.FolderId == folderId) {
+			return *job, true
+		}
+	}
+	return BackupJob{}, false
+}
+
+func (r *JobRegistry) find(id string) *BackupJob {
+	for _, job := range r.jobs {
+		if job.Id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// trim drops the oldest finished jobs once the history exceeds its limit,
+// queued and running jobs are always kept.
+func (r *JobRegistry) trim() {
+	excess := len(r.jobs) - r.limit
+	if excess <= 0 {
+		return
+	}
+
+	kept := r.jobs[:0]
+	for _, job := range r.jobs {
+		if excess > 0 && job.FinishedAt != nil {
+			excess--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	r.jobs = kept
+}
+
+func newJobId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
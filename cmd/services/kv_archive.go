@@ -0,0 +1,388 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	vault "github.com/hashicorp/vault/api"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	backupModeRaft    = "raft"
+	backupModeLogical = "logical"
+
+	kvArchiveExtension = "kvx"
+	kvArchiveVersion   = 1
+	kvArchiveKeySize   = 32
+)
+
+// kvArchiveMagic starts every archive, followed by the format version, the
+// GCM nonce and the sealed gzip'd JSON. Magic and version are authenticated
+// as additional data.
+var kvArchiveMagic = []byte("VBKV")
+
+// KVArchive is the content of a logical backup: every secret of the exported
+// KV v2 mounts with its metadata and either the current or all versions.
+type KVArchive struct {
+	Version     int              `json:"version"`
+	AppName     string           `json:"app_name"`
+	Cluster     string           `json:"cluster"`
+	CreatedAt   time.Time        `json:"created_at"`
+	AllVersions bool             `json:"all_versions"`
+	Mounts      []KVMountArchive `json:"mounts"`
+}
+
+type KVMountArchive struct {
+	Mount   string            `json:"mount"`
+	Secrets []KVSecretArchive `json:"secrets"`
+}
+
+type KVSecretArchive struct {
+	Path               string                 `json:"path"`
+	CurrentVersion     int                    `json:"current_version"`
+	MaxVersions        int                    `json:"max_versions"`
+	CASRequired        bool                   `json:"cas_required"`
+	DeleteVersionAfter string                 `json:"delete_version_after,omitempty"`
+	CustomMetadata     map[string]interface{} `json:"custom_metadata,omitempty"`
+	CreatedTime        time.Time              `json:"created_time"`
+	UpdatedTime        time.Time              `json:"updated_time"`
+	Versions           []KVVersionArchive     `json:"versions"`
+}
+
+// KVVersionArchive holds one version of a secret, Data is empty for deleted
+// and destroyed versions.
+type KVVersionArchive struct {
+	Version      int                    `json:"version"`
+	CreatedTime  time.Time              `json:"created_time"`
+	DeletionTime *time.Time             `json:"deletion_time,omitempty"`
+	Destroyed    bool                   `json:"destroyed,omitempty"`
+	Data         map[string]interface{} `json:"data,omitempty"`
+}
+
+func (a *KVArchive) secretCount() int {
+	count := 0
+	for _, mount := range a.Mounts {
+		count += len(mount.Secrets)
+	}
+	return count
+}
+
+// GetKVArchiveKey reads the archive encryption key, a base64 encoded 256 bit
+// AES key, from Vault.
+func (v *Vault) GetKVArchiveKey(ctx context.Context, ref config.SecretRef) ([]byte, error) {
+	encoded, err := v.GetKVSecretField(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("GetKVArchiveKey: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("GetKVArchiveKey: key in %s is not valid base64 %w", ref, err)
+	}
+	if len(key) != kvArchiveKeySize {
+		return nil, fmt.Errorf("GetKVArchiveKey: key in %s must be %d bytes, got %d", ref, kvArchiveKeySize, len(key))
+	}
+	return key, nil
+}
+
+// ExportKVv2 walks the KV v2 mounts and reads every secret with its metadata.
+// Only the current version is exported unless allVersions is set.
+func (v *Vault) ExportKVv2(ctx context.Context, mounts []string, allVersions bool) (*KVArchive, error) {
+	archive := &KVArchive{
+		Version:     kvArchiveVersion,
+		CreatedAt:   time.Now(),
+		AllVersions: allVersions,
+	}
+
+	for _, mount := range mounts {
+		mount = strings.Trim(mount, "/")
+		mountArchive := KVMountArchive{Mount: mount}
+		err := v.WalkKVv2(ctx, mount, func(secretPath string) error {
+			secret, err := v.exportKVv2Secret(ctx, mount, secretPath, allVersions)
+			if err != nil {
+				return err
+			}
+			mountArchive.Secrets = append(mountArchive.Secrets, *secret)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ExportKVv2: %w", err)
+		}
+		archive.Mounts = append(archive.Mounts, mountArchive)
+	}
+	return archive, nil
+}
+
+func (v *Vault) exportKVv2Secret(ctx context.Context, mount, secretPath string, allVersions bool) (*KVSecretArchive, error) {
+	metadata, err := v.GetKVv2Metadata(ctx, mount, secretPath)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &KVSecretArchive{
+		Path:           secretPath,
+		CurrentVersion: metadata.CurrentVersion,
+		MaxVersions:    metadata.MaxVersions,
+		CASRequired:    metadata.CASRequired,
+		CustomMetadata: metadata.CustomMetadata,
+		CreatedTime:    metadata.CreatedTime,
+		UpdatedTime:    metadata.UpdatedTime,
+	}
+	if metadata.DeleteVersionAfter > 0 {
+		secret.DeleteVersionAfter = metadata.DeleteVersionAfter.String()
+	}
+
+	var versions []int
+	for key := range metadata.Versions {
+		version, err := strconv.Atoi(key)
+		if err != nil {
+			continue
+		}
+		if allVersions || version == metadata.CurrentVersion {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+
+	for _, version := range versions {
+		versionMetadata := metadata.Versions[strconv.Itoa(version)]
+		exported := KVVersionArchive{
+			Version:     version,
+			CreatedTime: versionMetadata.CreatedTime,
+			Destroyed:   versionMetadata.Destroyed,
+		}
+		if !versionMetadata.DeletionTime.IsZero() {
+			deletionTime := versionMetadata.DeletionTime
+			exported.DeletionTime = &deletionTime
+		}
+		// deleted and destroyed versions keep their metadata but no data
+		if exported.DeletionTime == nil && !exported.Destroyed {
+			kvSecret, err := v.client.KVv2(mount).GetVersion(ctx, secretPath, version)
+			if err != nil && !errors.Is(err, vault.ErrSecretNotFound) {
+				return nil, fmt.Errorf("exportKVv2Secret: unable to read %s/%s version %d %w", mount, secretPath, version, err)
+			}
+			if kvSecret != nil {
+				exported.Data = kvSecret.Data
+			}
+		}
+		secret.Versions = append(secret.Versions, exported)
+	}
+	return secret, nil
+}
+
+// writeKVArchive encrypts the archive with AES-256-GCM and writes it to
+// filePath.
+func writeKVArchive(filePath string, archive *KVArchive, key []byte) error {
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	if err := json.NewEncoder(gz).Encode(archive); err != nil {
+		return fmt.Errorf("writeKVArchive: unable to encode archive %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("writeKVArchive: unable to compress archive %w", err)
+	}
+
+	gcm, err := newKVArchiveCipher(key)
+	if err != nil {
+		return fmt.Errorf("writeKVArchive: %w", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("writeKVArchive: unable to generate nonce %w", err)
+	}
+
+	header := append(append([]byte{}, kvArchiveMagic...), kvArchiveVersion)
+	out := append(append(header, nonce...), gcm.Seal(nil, nonce, plain.Bytes(), header)...)
+	if err := os.WriteFile(filePath, out, 0o600); err != nil {
+		return fmt.Errorf("writeKVArchive: unable to write %s %w", filePath, err)
+	}
+	return nil
+}
+
+// ReadKVArchive decrypts and decodes an archive written by a logical backup.
+func ReadKVArchive(filePath string, key []byte) (*KVArchive, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ReadKVArchive: unable to read %s %w", filePath, err)
+	}
+
+	headerSize := len(kvArchiveMagic) + 1
+	if len(data) < headerSize || !bytes.Equal(data[:len(kvArchiveMagic)], kvArchiveMagic) {
+		return nil, fmt.Errorf("ReadKVArchive: %s is not a KV archive", filePath)
+	}
+	if version := data[len(kvArchiveMagic)]; version != kvArchiveVersion {
+		return nil, fmt.Errorf("ReadKVArchive: unsupported archive version %d", version)
+	}
+
+	gcm, err := newKVArchiveCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("ReadKVArchive: %w", err)
+	}
+	if len(data) < headerSize+gcm.NonceSize() {
+		return nil, fmt.Errorf("ReadKVArchive: %s is truncated", filePath)
+	}
+	header, nonce := data[:headerSize], data[headerSize:headerSize+gcm.NonceSize()]
+	plain, err := gcm.Open(nil, nonce, data[headerSize+gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("ReadKVArchive: unable to decrypt %s, wrong key or corrupted file %w", filePath, err)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, fmt.Errorf("ReadKVArchive: %w", err)
+	}
+	var archive KVArchive
+	if err := json.NewDecoder(gz).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("ReadKVArchive: unable to decode archive %w", err)
+	}
+	return &archive, nil
+}
+
+func newKVArchiveCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive key %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// KVImportOptions controls ImportKVv2. Existing secrets are left alone unless
+// Overwrite is set, in which case the archived versions are written on top.
+type KVImportOptions struct {
+	DryRun    bool
+	Overwrite bool
+}
+
+// KVImportResult counts imported secrets. Conflicts are secrets changed by
+// someone else during the import, Failed covers every other error such as a
+// missing permission.
+type KVImportResult struct {
+	Imported  int
+	Skipped   int
+	Failed    int
+	Conflicts int
+	Versions  int
+}
+
+// errKVConflict marks a write rejected because the secret's version no
+// longer matched the check-and-set version.
+var errKVConflict = errors.New("check-and-set conflict")
+
+// ImportKVv2 writes the archived secrets back to their mounts. Versions are
+// replayed in order, so history is kept but renumbered on a fresh mount;
+// deleted and destroyed versions carry no data and are not restored. Every
+// write is a check-and-set against the version read before, so a secret
+// changed concurrently is reported as a conflict instead of being replaced.
+func (v *Vault) ImportKVv2(ctx context.Context, archive *KVArchive, options KVImportOptions) (KVImportResult, error) {
+	result := KVImportResult{}
+	for _, mount := range archive.Mounts {
+		for _, secret := range mount.Secrets {
+			written, err := v.importKVv2Secret(ctx, mount.Mount, secret, options)
+			switch {
+			case errors.Is(err, errKVConflict):
+				log.Printf("ImportKVv2: %v", err)
+				result.Conflicts++
+			case err != nil:
+				log.Printf("ImportKVv2: %v", err)
+				result.Failed++
+			case written == 0:
+				result.Skipped++
+			default:
+				result.Imported++
+				result.Versions += written
+			}
+		}
+	}
+
+	if result.Failed > 0 || result.Conflicts > 0 {
+		return result, fmt.Errorf("ImportKVv2: %d of %d secrets failed, %d changed concurrently",
+			result.Failed, archive.secretCount(), result.Conflicts)
+	}
+	return result, nil
+}
+
+func (v *Vault) importKVv2Secret(ctx context.Context, mount string, secret KVSecretArchive, options KVImportOptions) (int, error) {
+	// a path that does not exist yet is written with cas=0
+	cas := 0
+	existing, err := v.GetKVv2Metadata(ctx, mount, secret.Path)
+	switch {
+	case err == nil && !options.Overwrite:
+		log.Printf("ImportKVv2: %s/%s exists, skipping", mount, secret.Path)
+		return 0, nil
+	case err == nil:
+		cas = existing.CurrentVersion
+	case !errors.Is(err, vault.ErrSecretNotFound):
+		return 0, err
+	}
+
+	var versions []KVVersionArchive
+	for _, version := range secret.Versions {
+		if version.Data != nil {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		log.Printf("ImportKVv2: %s/%s has no readable version, skipping", mount, secret.Path)
+		return 0, nil
+	}
+	if options.DryRun {
+		log.Printf("ImportKVv2: would write %d versions of %s/%s", len(versions), mount, secret.Path)
+		return len(versions), nil
+	}
+
+	kv := v.client.KVv2(mount)
+	for _, version := range versions {
+		written, err := kv.Put(ctx, secret.Path, version.Data, vault.WithCheckAndSet(cas))
+		if isCASMismatch(err) {
+			return 0, fmt.Errorf("importKVv2Secret: %s/%s changed during the import %w", mount, secret.Path, errKVConflict)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("importKVv2Secret: unable to write %s/%s version %d %w", mount, secret.Path, version.Version, err)
+		}
+		cas = written.VersionMetadata.Version
+	}
+
+	metadata := vault.KVMetadataPutInput{
+		CASRequired:    secret.CASRequired,
+		CustomMetadata: secret.CustomMetadata,
+		MaxVersions:    secret.MaxVersions,
+	}
+	if secret.DeleteVersionAfter != "" {
+		if metadata.DeleteVersionAfter, err = time.ParseDuration(secret.DeleteVersionAfter); err != nil {
+			return 0, fmt.Errorf("importKVv2Secret: invalid delete_version_after of %s/%s %w", mount, secret.Path, err)
+		}
+	}
+	if err := kv.PutMetadata(ctx, secret.Path, metadata); err != nil {
+		return 0, fmt.Errorf("importKVv2Secret: unable to write metadata of %s/%s %w", mount, secret.Path, err)
+	}
+	return len(versions), nil
+}
+
+// isCASMismatch reports whether Vault rejected a write because the
+// check-and-set version did not match.
+func isCASMismatch(err error) bool {
+	var respErr *vault.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != 400 {
+		return false
+	}
+	for _, msg := range respErr.Errors {
+		if strings.Contains(msg, "check-and-set") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestArchiveKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, kvArchiveKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKVArchiveRoundTrip(t *testing.T) {
+	key := newTestArchiveKey(t)
+	archive := &KVArchive{
+		Version:   kvArchiveVersion,
+		AppName:   "vault_backup",
+		Cluster:   "prod",
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Mounts: []KVMountArchive{{
+			Mount: "secret",
+			Secrets: []KVSecretArchive{{
+				Path:           "app/db",
+				CurrentVersion: 2,
+				Versions: []KVVersionArchive{
+					{Version: 1, Destroyed: true},
+					{Version: 2, Data: map[string]interface{}{"password": "s3cret"}},
+				},
+			}},
+		}},
+	}
+
+	filePath := filepath.Join(t.TempDir(), "backup."+kvArchiveExtension)
+	if err := writeKVArchive(filePath, archive, key); err != nil {
+		t.Fatalf("writeKVArchive() = %v", err)
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("s3cret")) || !bytes.HasPrefix(raw, kvArchiveMagic) {
+		t.Fatal("archive is not encrypted behind the magic header")
+	}
+
+	got, err := ReadKVArchive(filePath, key)
+	if err != nil {
+		t.Fatalf("ReadKVArchive() = %v", err)
+	}
+	if got.Cluster != "prod" || !got.CreatedAt.Equal(archive.CreatedAt) || got.secretCount() != 1 {
+		t.Errorf("unexpected archive %+v", got)
+	}
+	versions := got.Mounts[0].Secrets[0].Versions
+	if len(versions) != 2 || !versions[0].Destroyed || versions[1].Data["password"] != "s3cret" {
+		t.Errorf("unexpected versions %+v", versions)
+	}
+}
+
+func TestKVArchiveRejectsWrongKey(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "backup."+kvArchiveExtension)
+	if err := writeKVArchive(filePath, &KVArchive{Version: kvArchiveVersion}, newTestArchiveKey(t)); err != nil {
+		t.Fatalf("writeKVArchive() = %v", err)
+	}
+
+	if _, err := ReadKVArchive(filePath, newTestArchiveKey(t)); err == nil {
+		t.Error("ReadKVArchive() decrypted with the wrong key")
+	}
+}
+
+func TestKVArchiveRejectsTamperedFile(t *testing.T) {
+	key := newTestArchiveKey(t)
+	filePath := filepath.Join(t.TempDir(), "backup."+kvArchiveExtension)
+	if err := writeKVArchive(filePath, &KVArchive{Version: kvArchiveVersion, Cluster: "prod"}, key); err != nil {
+		t.Fatalf("writeKVArchive() = %v", err)
+	}
+
+	raw, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(filePath, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadKVArchive(filePath, key); err == nil {
+		t.Error("ReadKVArchive() accepted a tampered archive")
+	}
+}
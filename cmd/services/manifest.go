@@ -0,0 +1,37 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// BackupManifest describes a snapshot or logical archive and why it was taken. It is stored
+// next to the snapshot and uploaded with it.
+type BackupManifest struct {
+	Version      int          `json:"version"`
+	AppName      string       `json:"app_name"`
+	Cluster      string       `json:"cluster"`
+	JobId        string       `json:"job_id"`
+	Trigger      string       `json:"trigger"`
+	Mode         string       `json:"mode"`
+	CreatedAt    time.Time    `json:"created_at"`
+	SnapshotFile string       `json:"snapshot_file"`
+	SnapshotSize int64        `json:"snapshot_size"`
+	Events       []VaultEvent `json:"events,omitempty"`
+	Node         *NodeInfo    `json:"node,omitempty"`
+}
+
+const manifestVersion = 1
+
+func writeManifest(filePath string, manifest BackupManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("writeManifest: %w", err)
+	}
+	if err := os.WriteFile(filePath, b, 0o644); err != nil {
+		return fmt.Errorf("writeManifest: unable to write %s %w", filePath, err)
+	}
+	return nil
+}
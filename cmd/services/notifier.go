@@ -1,47 +1,202 @@
 package services
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
-	"net/smtp"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
 	"strings"
+	"time"
 )
 
+type NotificationEvent string
+
+const (
+	BackupFailedEvent          NotificationEvent = "backup_failed"
+	BackupSucceededEvent       NotificationEvent = "backup_succeeded"
+	RetentionDeletedEvent      NotificationEvent = "retention_deleted"
+	RetentionFailedEvent       NotificationEvent = "retention_failed"
+	TokenReloginEvent          NotificationEvent = "token_relogin"
+	TokenReloginFailedEvent    NotificationEvent = "token_relogin_failed"
+	WebsocketDisconnectedEvent NotificationEvent = "websocket_disconnected"
+	WebsocketReconnectedEvent  NotificationEvent = "websocket_reconnected"
+	SLABreachedEvent           NotificationEvent = "sla_breached"
+	SLARecoveredEvent          NotificationEvent = "sla_recovered"
+	DigestEvent                NotificationEvent = "digest"
+)
+
+var notificationEvents = []NotificationEvent{
+	BackupFailedEvent,
+	BackupSucceededEvent,
+	RetentionDeletedEvent,
+	RetentionFailedEvent,
+	TokenReloginEvent,
+	TokenReloginFailedEvent,
+	WebsocketDisconnectedEvent,
+	WebsocketReconnectedEvent,
+	SLABreachedEvent,
+	SLARecoveredEvent,
+	DigestEvent,
+}
+
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	}
+	return 0
+}
+
+// Notification carries the structured fields of an event. Subject and body
+// are rendered from the event's template before delivery unless the sender
+// sets them.
+type Notification struct {
+	Event         NotificationEvent
+	Severity      Severity
+	Subject       string
+	Body          string
+	JobId         string
+	Trigger       string
+	Destination   string
+	Error         string
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	FileName      string
+	FileId        string
+	Size          int64
+	Count         int
+	RetentionDays int
+	SLAWindow     time.Duration
+	Since         time.Time
+	LastSuccess   time.Time
+	Downtime      time.Duration
+	CatchUpBackup bool
+}
+
 type Notifier interface {
-	Notify(messageSubject, messageBody string)
+	Notify(notification Notification) error
 }
 
-func SendNotification(n Notifier, messageSubject, messageBody string) {
-	n.Notify(messageSubject, messageBody)
+func SendNotification(n Notifier, notification Notification) {
+	if err := n.Notify(notification); err != nil {
+		log.Printf("Warning: SendNotification - %v", err)
+	}
 }
 
 type EmailNotifier struct {
 	recipients []string
 	mailbox    string
-	address    string
-	auth       smtp.Auth
+	transport  *smtpTransport
+	templates  *NotificationTemplates
 }
 
-func GetEmailNotifier(recipients []string, username, password, host, port, mailbox string) (EmailNotifier, error) {
-	auth := smtp.PlainAuth("", username, password, host)
+func GetEmailNotifier(
+	recipients []string,
+	mailbox string,
+	transportOptions SMTPTransportOptions,
+	templates *NotificationTemplates) (EmailNotifier, error) {
+	transport, err := newSMTPTransport(transportOptions)
+	if err != nil {
+		return EmailNotifier{}, fmt.Errorf("GetEmailNotifier: %w", err)
+	}
+
 	return EmailNotifier{
 		recipients: recipients,
 		mailbox:    mailbox,
-		address:    fmt.Sprintf("%s:%s", host, port),
-		auth:       auth}, nil
+		transport:  transport,
+		templates:  templates}, nil
 }
 
-func (e EmailNotifier) Notify(messageSubject, messageBody string) {
+func (e EmailNotifier) Notify(notification Notification) error {
 	from := e.mailbox
 
-	msg := fmt.Sprintf("To: %s \r\n", strings.Join(e.recipients, ",")) +
-		fmt.Sprintf("Subject: %s \r\n\r\n", messageSubject) +
-		fmt.Sprintf("%s \r\n", messageBody)
+	subject, textBody, htmlBody, err := e.templates.Render(notification)
+	if err != nil {
+		return fmt.Errorf("Notify: error while rendering email %w", err)
+	}
 
-	err := smtp.SendMail(e.address, e.auth, from, e.recipients, []byte(msg))
+	msg, err := e.buildMessage(subject, textBody, htmlBody)
 	if err != nil {
-		log.Printf("Warning: SendEmail - error while sending email %v", err)
-	} else {
-		log.Println("Email sent successfully")
+		return fmt.Errorf("Notify: error while building email %w", err)
+	}
+
+	err = e.transport.send(from, e.recipients, msg)
+	if err != nil {
+		return fmt.Errorf("Notify: error while sending email %w", err)
+	}
+	log.Println("Email sent successfully")
+	return nil
+}
+
+// buildMessage assembles a multipart/alternative message with plain text and
+// HTML parts, both quoted-printable encoded.
+func (e EmailNotifier) buildMessage(subject, textBody, htmlBody string) ([]byte, error) {
+	body := bytes.Buffer{}
+	mw := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", textBody},
+		{"text/html; charset=utf-8", htmlBody},
+	}
+	for _, part := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	header := bytes.Buffer{}
+	fmt.Fprintf(&header, "From: %s\r\n", e.mailbox)
+	fmt.Fprintf(&header, "To: %s\r\n", strings.Join(e.recipients, ", "))
+	fmt.Fprintf(&header, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&header, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&header, "Message-ID: %s\r\n", e.messageId())
+	fmt.Fprintf(&header, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&header, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+
+	return append(header.Bytes(), body.Bytes()...), nil
+}
+
+func (e EmailNotifier) messageId() string {
+	domain := "localhost"
+	if at := strings.LastIndex(e.mailbox, "@"); at >= 0 {
+		domain = strings.TrimSuffix(e.mailbox[at+1:], ">")
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("<%d@%s>", time.Now().UnixNano(), domain)
 	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().Unix(), hex.EncodeToString(b), domain)
 }
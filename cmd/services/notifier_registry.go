@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	emailNotifierType     = "email"
+	slackNotifierType     = "slack"
+	teamsNotifierType     = "teams"
+	discordNotifierType   = "discord"
+	webhookNotifierType   = "webhook"
+	pagerDutyNotifierType = "pagerduty"
+
+	emailAccessTokenTimeout = 10 * time.Second
+)
+
+// NotifierRegistry holds the notification channels configured under
+// "notifiers", keyed by name in configuration order.
+type NotifierRegistry struct {
+	notifiers map[string]Notifier
+	resolvers map[string]bool
+	names     []string
+	templates *NotificationTemplates
+}
+
+func GetNotifierRegistry(
+	ctx context.Context,
+	appConfig *config.AppConfig,
+	vault *Vault) (*NotifierRegistry, error) {
+	r := &NotifierRegistry{notifiers: make(map[string]Notifier), resolvers: make(map[string]bool)}
+
+	outbox, err := GetOutbox(appConfig.Outbox)
+	if err != nil {
+		return nil, fmt.Errorf("GetNotifierRegistry: %w", err)
+	}
+
+	r.templates, err = GetNotificationTemplates(
+		appConfig.TemplatesDir,
+		appConfig.AppName,
+		appConfig.VaultConfig.ClusterName)
+	if err != nil {
+		return nil, fmt.Errorf("GetNotifierRegistry: unable to load notification templates %w", err)
+	}
+
+	notifierConfigs := appConfig.Notifiers
+	if len(notifierConfigs) == 0 {
+		notifierConfigs = []config.NotifierConfig{{Name: emailNotifierType, Type: emailNotifierType}}
+	}
+
+	for _, nc := range notifierConfigs {
+		if nc.Name == "" {
+			return nil, fmt.Errorf("GetNotifierRegistry: notifier of type %s has no name", nc.Type)
+		}
+		if _, ok := r.notifiers[nc.Name]; ok {
+			return nil, fmt.Errorf("GetNotifierRegistry: duplicate notifier name %s", nc.Name)
+		}
+
+		n, err := newNotifier(ctx, nc, appConfig, vault, r.templates)
+		if err != nil {
+			return nil, fmt.Errorf("GetNotifierRegistry: notifier %s: %w", nc.Name, err)
+		}
+		_, r.resolvers[nc.Name] = n.(incidentResolver)
+		if outbox != nil {
+			n = outbox.Channel(nc.Name, n)
+		}
+
+		n, err = throttle(nc, n)
+		if err != nil {
+			return nil, fmt.Errorf("GetNotifierRegistry: notifier %s: %w", nc.Name, err)
+		}
+		r.notifiers[nc.Name] = n
+		r.names = append(r.names, nc.Name)
+	}
+
+	if outbox != nil {
+		if fallback := appConfig.Outbox.FallbackChannel; fallback != "" {
+			if _, ok := r.notifiers[fallback]; !ok {
+				return nil, fmt.Errorf("GetNotifierRegistry: unknown outbox fallback channel %s", fallback)
+			}
+		}
+		go outbox.Run(ctx)
+	}
+
+	return r, nil
+}
+
+func newNotifier(
+	ctx context.Context,
+	nc config.NotifierConfig,
+	appConfig *config.AppConfig,
+	vault *Vault,
+	templates *NotificationTemplates) (Notifier, error) {
+	appName := appConfig.AppName
+
+	if nc.Type != emailNotifierType && nc.Type != pagerDutyNotifierType && nc.WebhookUrl == "" {
+		return nil, fmt.Errorf("webhook_url is required for %s notifier", nc.Type)
+	}
+
+	switch nc.Type {
+	case emailNotifierType:
+		return newEmailNotifier(ctx, appConfig, vault, templates)
+	case slackNotifierType:
+		return GetSlackNotifier(nc.WebhookUrl, appName), nil
+	case teamsNotifierType:
+		return GetTeamsNotifier(nc.WebhookUrl, appName), nil
+	case discordNotifierType:
+		return GetDiscordNotifier(nc.WebhookUrl, appName), nil
+	case webhookNotifierType:
+		hmacKey, err := vault.GetKVSecretField(ctx, nc.HMACSecret)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read HMAC key %w", err)
+		}
+		maxRetries := nc.MaxRetries
+		if maxRetries <= 0 {
+			maxRetries = defaultWebhookMaxRetries
+		}
+		if appConfig.Outbox.Dir != "" {
+			// the outbox retries with its own backoff without blocking the caller
+			maxRetries = 0
+		}
+		return GetSignedWebhookNotifier(nc.WebhookUrl, hmacKey, appName, appConfig.VaultConfig.ClusterName, maxRetries), nil
+	case pagerDutyNotifierType:
+		routingKey, err := vault.GetKVSecretField(ctx, nc.RoutingKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read routing key %w", err)
+		}
+		return GetPagerDutyNotifier(nc.WebhookUrl, routingKey, appName, appConfig.VaultConfig.ClusterName), nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", nc.Type)
+}
+
+// newEmailNotifier reads the SMTP credentials from Vault and builds the email
+// notifier configured under vault. It only runs when an email channel is
+// configured, so other deployments need no email secret.
+func newEmailNotifier(
+	ctx context.Context,
+	appConfig *config.AppConfig,
+	vault *Vault,
+	templates *NotificationTemplates) (*EmailNotifier, error) {
+	// an unauthenticated relay needs no credentials in Vault
+	emailCredentials := map[string]string{}
+	var accessToken func() (string, error)
+	if appConfig.VaultConfig.EmailAuthMechanism != smtpAuthNone {
+		var err error
+		emailCredentials, err = vault.GetKVSecretFields(ctx, appConfig.Secrets.Email, "username", "password")
+		if err != nil {
+			return nil, fmt.Errorf("unable to obtain email credentials from vault %w", err)
+		}
+		accessToken = emailAccessToken(vault, appConfig.Secrets.Email)
+	}
+
+	emailNotifier, err := GetEmailNotifier(
+		appConfig.VaultConfig.NotifyEmails,
+		appConfig.VaultConfig.Mailbox,
+		SMTPTransportOptions{
+			Host:          appConfig.VaultConfig.EmailHost,
+			Port:          appConfig.VaultConfig.EmailHostPort,
+			TLSMode:       appConfig.VaultConfig.EmailTLSMode,
+			CAFile:        appConfig.VaultConfig.EmailCAFile,
+			Timeout:       appConfig.VaultConfig.EmailTimeout,
+			AuthMechanism: appConfig.VaultConfig.EmailAuthMechanism,
+			Username:      emailCredentials["username"],
+			Password:      emailCredentials["password"],
+			AccessToken:   accessToken,
+		},
+		templates)
+	if err != nil {
+		return nil, err
+	}
+	return &emailNotifier, nil
+}
+
+// emailAccessToken re-reads the password field of the email secret, which
+// holds the OAuth2 access token for xoauth2, so a token refreshed in Vault is
+// picked up by the next email.
+func emailAccessToken(vault *Vault, ref config.SecretRef) func() (string, error) {
+	return func() (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), emailAccessTokenTimeout)
+		defer cancel()
+
+		fields, err := vault.GetKVSecretFields(ctx, ref, "password")
+		if err != nil {
+			return "", err
+		}
+		return fields["password"], nil
+	}
+}
+
+func throttle(nc config.NotifierConfig, n Notifier) (Notifier, error) {
+	if nc.DedupWindow == "" && nc.RateLimit <= 0 {
+		return n, nil
+	}
+
+	var dedupWindow, rateInterval time.Duration
+	var err error
+	if nc.DedupWindow != "" {
+		if dedupWindow, err = time.ParseDuration(nc.DedupWindow); err != nil {
+			return nil, fmt.Errorf("invalid dedup_window %w", err)
+		}
+	}
+	if nc.RateLimitInterval != "" {
+		if rateInterval, err = time.ParseDuration(nc.RateLimitInterval); err != nil {
+			return nil, fmt.Errorf("invalid rate_limit_interval %w", err)
+		}
+	}
+	return newThrottledNotifier(nc.Name, n, dedupWindow, nc.RateLimit, rateInterval), nil
+}
+
+func (r *NotifierRegistry) Get(name string) (Notifier, bool) {
+	n, ok := r.notifiers[name]
+	return n, ok
+}
+
+// FanOut returns a notifier delivering through the configured routes. Without
+// routes every warning and critical event plus SLA recoveries and digests go
+// to all channels except incident channels, which only get backup failures
+// and SLA breaches.
+func (r *NotifierRegistry) FanOut(routes []config.NotificationRoute) (*FanOutNotifier, error) {
+	if len(routes) == 0 {
+		routes = r.defaultRoutes()
+	}
+
+	f := &FanOutNotifier{templates: r.templates}
+	for _, name := range r.names {
+		f.channels = append(f.channels, namedNotifier{name: name, notifier: r.notifiers[name], resolver: r.resolvers[name]})
+	}
+
+	for i, route := range routes {
+		for _, name := range route.Channels {
+			if _, ok := r.notifiers[name]; !ok {
+				return nil, fmt.Errorf("FanOut: route %d references unknown channel %s", i, name)
+			}
+		}
+		for _, event := range route.Events {
+			if !slices.Contains(notificationEvents, NotificationEvent(event)) {
+				return nil, fmt.Errorf("FanOut: route %d has unknown event %s", i, event)
+			}
+		}
+		if route.MinSeverity != "" && !validSeverity(Severity(route.MinSeverity)) {
+			return nil, fmt.Errorf("FanOut: route %d has unknown min_severity %s", i, route.MinSeverity)
+		}
+		f.routes = append(f.routes, route)
+	}
+
+	return f, nil
+}
+
+func (r *NotifierRegistry) defaultRoutes() []config.NotificationRoute {
+	var channels, incidentChannels []string
+	for _, name := range r.names {
+		if r.resolvers[name] {
+			incidentChannels = append(incidentChannels, name)
+		} else {
+			channels = append(channels, name)
+		}
+	}
+
+	var routes []config.NotificationRoute
+	if len(channels) > 0 {
+		routes = append(routes,
+			config.NotificationRoute{MinSeverity: string(SeverityWarning), Channels: channels},
+			config.NotificationRoute{Events: []string{string(SLARecoveredEvent), string(DigestEvent)}, Channels: channels})
+	}
+	if len(incidentChannels) > 0 {
+		routes = append(routes, config.NotificationRoute{
+			Events:   []string{string(BackupFailedEvent), string(SLABreachedEvent)},
+			Channels: incidentChannels,
+		})
+	}
+	return routes
+}
+
+// incidentResolver is implemented by channels that track open incidents and
+// therefore always receive success and recovery notifications.
+type incidentResolver interface {
+	resolvesIncidents()
+}
+
+type namedNotifier struct {
+	name     string
+	notifier Notifier
+	resolver bool
+}
+
+// FanOutNotifier renders each message from its event template and sends it
+// to the channels selected by its routes. A failing channel is logged and
+// does not prevent delivery to the others.
+type FanOutNotifier struct {
+	channels  []namedNotifier
+	routes    []config.NotificationRoute
+	templates *NotificationTemplates
+}
+
+func (f *FanOutNotifier) Notify(notification Notification) error {
+	if f.templates != nil {
+		var err error
+		if notification, err = f.templates.Compose(notification); err != nil {
+			log.Printf("Warning: FanOutNotifier - %v", err)
+		}
+	}
+
+	var errs []error
+	sent := 0
+	for _, c := range f.channels {
+		if !f.routed(c, notification) {
+			continue
+		}
+
+		sent++
+		if err := c.notifier.Notify(notification); err != nil {
+			log.Printf("Warning: FanOutNotifier - channel %s failed %v", c.name, err)
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+
+	if len(errs) == sent && len(errs) > 0 {
+		return fmt.Errorf("FanOutNotifier: all channels failed %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (f *FanOutNotifier) routed(c namedNotifier, n Notification) bool {
+	if c.resolver {
+		if n.Event == BackupSucceededEvent || n.Event == SLARecoveredEvent {
+			return true
+		}
+	}
+
+	for _, route := range f.routes {
+		if routeMatches(route, n) && (len(route.Channels) == 0 || slices.Contains(route.Channels, c.name)) {
+			return true
+		}
+	}
+	return false
+}
+
+func routeMatches(route config.NotificationRoute, n Notification) bool {
+	if len(route.Events) > 0 && !slices.Contains(route.Events, string(n.Event)) {
+		return false
+	}
+	return n.Severity.rank() >= Severity(route.MinSeverity).rank()
+}
+
+func validSeverity(s Severity) bool {
+	return s == SeverityInfo || s == SeverityWarning || s == SeverityCritical
+}
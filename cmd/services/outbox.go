@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	defaultOutboxMaxAttempts    = 5
+	defaultOutboxInitialBackoff = 30 * time.Second
+	defaultOutboxMaxBackoff     = 30 * time.Minute
+	outboxPollInterval          = 10 * time.Second
+	outboxFileSuffix            = ".json"
+)
+
+type outboxEntry struct {
+	Id           string       `json:"id"`
+	Channel      string       `json:"channel"`
+	Notification Notification `json:"notification"`
+	Attempts     int          `json:"attempts"`
+	CreatedAt    time.Time    `json:"created_at"`
+	NextAttempt  time.Time    `json:"next_attempt"`
+	LastError    string       `json:"last_error,omitempty"`
+}
+
+// Outbox persists notifications on disk before delivery and retries failed
+// deliveries with exponential backoff, also across restarts. After
+// maxAttempts the notification is escalated to the fallback channel.
+type Outbox struct {
+	mu              sync.Mutex
+	dir             string
+	maxAttempts     int
+	initialBackoff  time.Duration
+	maxBackoff      time.Duration
+	fallbackChannel string
+	channels        map[string]Notifier
+	wake            chan struct{}
+}
+
+func GetOutbox(outboxConfig config.OutboxConfig) (*Outbox, error) {
+	if outboxConfig.Dir == "" {
+		return nil, nil
+	}
+
+	o := &Outbox{
+		dir:             outboxConfig.Dir,
+		maxAttempts:     outboxConfig.MaxAttempts,
+		initialBackoff:  defaultOutboxInitialBackoff,
+		maxBackoff:      defaultOutboxMaxBackoff,
+		fallbackChannel: outboxConfig.FallbackChannel,
+		channels:        make(map[string]Notifier),
+		wake:            make(chan struct{}, 1),
+	}
+	if o.maxAttempts <= 0 {
+		o.maxAttempts = defaultOutboxMaxAttempts
+	}
+
+	var err error
+	if outboxConfig.InitialBackoff != "" {
+		if o.initialBackoff, err = time.ParseDuration(outboxConfig.InitialBackoff); err != nil {
+			return nil, fmt.Errorf("GetOutbox: invalid initial_backoff %w", err)
+		}
+	}
+	if outboxConfig.MaxBackoff != "" {
+		if o.maxBackoff, err = time.ParseDuration(outboxConfig.MaxBackoff); err != nil {
+			return nil, fmt.Errorf("GetOutbox: invalid max_backoff %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(o.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("GetOutbox: unable to create outbox directory %s, %w", o.dir, err)
+	}
+	return o, nil
+}
+
+// Channel registers the channel under name and returns a notifier that
+// queues into the outbox instead of delivering directly.
+func (o *Outbox) Channel(name string, n Notifier) Notifier {
+	o.mu.Lock()
+	o.channels[name] = n
+	o.mu.Unlock()
+	return &outboxChannel{outbox: o, name: name}
+}
+
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.deliverDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+func (o *Outbox) enqueue(channel string, notification Notification) error {
+	now := time.Now()
+	entry := outboxEntry{
+		Id:           fmt.Sprintf("%d-%s", now.UnixNano(), newJobId()),
+		Channel:      channel,
+		Notification: notification,
+		CreatedAt:    now,
+		NextAttempt:  now,
+	}
+	if err := o.write(entry); err != nil {
+		return fmt.Errorf("enqueue: %w", err)
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *Outbox) deliverDue() {
+	files, err := filepath.Glob(filepath.Join(o.dir, "*"+outboxFileSuffix))
+	if err != nil {
+		log.Printf("Warning: Outbox - unable to list %s %v", o.dir, err)
+		return
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		entry, err := o.read(file)
+		if err != nil {
+			log.Printf("Warning: Outbox - dropping unreadable entry %s %v", file, err)
+			os.Remove(file)
+			continue
+		}
+		if time.Now().Before(entry.NextAttempt) {
+			continue
+		}
+		o.deliver(file, entry)
+	}
+}
+
+func (o *Outbox) deliver(file string, entry outboxEntry) {
+	o.mu.Lock()
+	channel, ok := o.channels[entry.Channel]
+	o.mu.Unlock()
+
+	if !ok {
+		log.Printf("Warning: Outbox - channel %s is no longer configured, escalating entry %s", entry.Channel, entry.Id)
+		entry.LastError = "channel is no longer configured"
+		o.escalate(entry)
+		os.Remove(file)
+		return
+	}
+
+	err := channel.Notify(entry.Notification)
+	if err == nil {
+		if err := os.Remove(file); err != nil {
+			log.Printf("Warning: Outbox - unable to remove delivered entry %s %v", file, err)
+		}
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.Attempts < o.maxAttempts {
+		backoff := o.initialBackoff << (entry.Attempts - 1)
+		if backoff <= 0 || backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+		entry.NextAttempt = time.Now().Add(backoff)
+		log.Printf("Warning: Outbox - delivery to %s failed (attempt %d/%d), retrying in %s: %v",
+			entry.Channel, entry.Attempts, o.maxAttempts, backoff, err)
+		if err := o.write(entry); err != nil {
+			log.Printf("Warning: Outbox - unable to update entry %s %v", file, err)
+		}
+		return
+	}
+
+	log.Printf("Warning: Outbox - giving up on delivery to %s after %d attempts: %v", entry.Channel, entry.Attempts, err)
+	o.escalate(entry)
+	if err := os.Remove(file); err != nil {
+		log.Printf("Warning: Outbox - unable to remove failed entry %s %v", file, err)
+	}
+}
+
+func (o *Outbox) escalate(entry outboxEntry) {
+	if o.fallbackChannel == "" || o.fallbackChannel == entry.Channel {
+		return
+	}
+
+	n := entry.Notification
+	n.Subject = fmt.Sprintf("[undelivered via %s] %s", entry.Channel, n.Subject)
+	n.Body = fmt.Sprintf("This notification could not be delivered via %s after %d attempts (last error: %s).\n\n%s",
+		entry.Channel, entry.Attempts, entry.LastError, n.Body)
+	if err := o.enqueue(o.fallbackChannel, n); err != nil {
+		log.Printf("Warning: Outbox - unable to escalate to %s %v", o.fallbackChannel, err)
+	}
+}
+
+func (o *Outbox) write(entry outboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to encode outbox entry %w", err)
+	}
+
+	path := filepath.Join(o.dir, entry.Id+outboxFileSuffix)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("unable to write outbox entry %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("unable to write outbox entry %w", err)
+	}
+	return nil
+}
+
+func (o *Outbox) read(file string) (outboxEntry, error) {
+	entry := outboxEntry{}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, err
+	}
+	if entry.Id == "" || !strings.HasPrefix(filepath.Base(file), entry.Id) {
+		return entry, errors.New("entry id does not match file name")
+	}
+	return entry, nil
+}
+
+type outboxChannel struct {
+	outbox *Outbox
+	name   string
+}
+
+func (c *outboxChannel) Notify(notification Notification) error {
+	return c.outbox.enqueue(c.name, notification)
+}
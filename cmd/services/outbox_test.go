@@ -0,0 +1,91 @@
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+func newTestOutbox(t *testing.T, maxAttempts int, fallback string) *Outbox {
+	t.Helper()
+	outbox, err := GetOutbox(config.OutboxConfig{
+		Dir:             t.TempDir(),
+		MaxAttempts:     maxAttempts,
+		InitialBackoff:  "1ms",
+		MaxBackoff:      "1ms",
+		FallbackChannel: fallback,
+	})
+	if err != nil {
+		t.Fatalf("GetOutbox() = %v", err)
+	}
+	return outbox
+}
+
+func pendingEntries(t *testing.T, o *Outbox) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(o.dir, "*"+outboxFileSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestOutboxRetriesFailedDelivery(t *testing.T) {
+	outbox := newTestOutbox(t, 3, "")
+	webhook := &recordingNotifier{failures: 1}
+	channel := outbox.Channel("webhook", webhook)
+
+	if err := channel.Notify(Notification{Event: BackupFailedEvent, Subject: "backup failed"}); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	if got := len(webhook.notifications()); got != 0 {
+		t.Fatalf("delivered %d notifications before the outbox ran", got)
+	}
+
+	outbox.deliverDue()
+	if pendingEntries(t, outbox) != 1 {
+		t.Fatal("failed delivery was not kept for a retry")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	outbox.deliverDue()
+	if got := len(webhook.notifications()); got != 2 {
+		t.Errorf("got %d delivery attempts, want 2", got)
+	}
+	if pendingEntries(t, outbox) != 0 {
+		t.Error("delivered entry is still pending")
+	}
+}
+
+func TestOutboxEscalatesToFallback(t *testing.T) {
+	outbox := newTestOutbox(t, 2, "email")
+	webhook := &recordingNotifier{failures: 10}
+	email := &recordingNotifier{}
+	channel := outbox.Channel("webhook", webhook)
+	outbox.Channel("email", email)
+
+	if err := channel.Notify(Notification{Event: BackupFailedEvent, Subject: "backup failed", Body: "details"}); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		outbox.deliverDue()
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := len(webhook.notifications()); got != 2 {
+		t.Errorf("got %d delivery attempts via webhook, want 2", got)
+	}
+	escalated := email.notifications()
+	if len(escalated) != 1 {
+		t.Fatalf("got %d escalations, want 1", len(escalated))
+	}
+	if !strings.HasPrefix(escalated[0].Subject, "[undelivered via webhook] backup failed") ||
+		!strings.Contains(escalated[0].Body, "after 2 attempts") || !strings.Contains(escalated[0].Body, "details") {
+		t.Errorf("unexpected escalation %+v", escalated[0])
+	}
+	if pendingEntries(t, outbox) != 0 {
+		t.Error("entries are still pending after escalation")
+	}
+}
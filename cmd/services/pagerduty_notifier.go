@@ -0,0 +1,142 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	defaultPagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+	pagerDutyTrigger          = "trigger"
+	pagerDutyResolve          = "resolve"
+)
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component,omitempty"`
+	Group         string            `json:"group,omitempty"`
+	Class         string            `json:"class,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// PagerDutyNotifier triggers Events v2 incidents for failed backups and SLA
+// breaches and resolves them once a backup with the same dedup key succeeds
+// or the SLA recovers. Other events are ignored.
+type PagerDutyNotifier struct {
+	mu         sync.Mutex
+	eventsUrl  string
+	routingKey string
+	appName    string
+	cluster    string
+	client     *http.Client
+	// open tracks dedup keys by whether an incident may still be open, keys
+	// not seen since startup are resolved on their first success
+	open map[string]bool
+}
+
+func GetPagerDutyNotifier(eventsUrl, routingKey, appName, cluster string) *PagerDutyNotifier {
+	if eventsUrl == "" {
+		eventsUrl = defaultPagerDutyEventsUrl
+	}
+	return &PagerDutyNotifier{
+		eventsUrl:  eventsUrl,
+		routingKey: routingKey,
+		appName:    appName,
+		cluster:    cluster,
+		client:     &http.Client{Timeout: webhookTimeout},
+		open:       make(map[string]bool),
+	}
+}
+
+func (p *PagerDutyNotifier) resolvesIncidents() {}
+
+func (p *PagerDutyNotifier) Notify(notification Notification) error {
+	key := p.dedupKey(notification)
+
+	switch notification.Event {
+	case BackupSucceededEvent, SLARecoveredEvent:
+		p.mu.Lock()
+		open, seen := p.open[key]
+		p.mu.Unlock()
+		if seen && !open {
+			return nil
+		}
+		if err := p.send(pagerDutyEvent{RoutingKey: p.routingKey, EventAction: pagerDutyResolve, DedupKey: key}); err != nil {
+			return err
+		}
+		p.setOpen(key, false)
+		return nil
+	case BackupFailedEvent, SLABreachedEvent:
+	default:
+		// only failures page, everything else has no incident to resolve
+		return nil
+	}
+
+	event := pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: pagerDutyTrigger,
+		DedupKey:    key,
+		Payload: &pagerDutyPayload{
+			Summary:   notification.Subject,
+			Source:    p.cluster,
+			Severity:  pagerDutySeverity(notification.Severity),
+			Component: p.appName,
+			Group:     notification.Destination,
+			Class:     string(notification.Event),
+			CustomDetails: map[string]string{
+				"job_id":  notification.JobId,
+				"trigger": notification.Trigger,
+				"error":   notification.Error,
+				"message": notification.Body,
+			},
+		},
+	}
+	if err := p.send(event); err != nil {
+		return err
+	}
+	p.setOpen(key, true)
+	return nil
+}
+
+// dedupKey is stable per trigger type and destination, so repeated failures
+// update one incident and the next success for the same pair resolves it.
+func (p *PagerDutyNotifier) dedupKey(n Notification) string {
+	switch n.Event {
+	case SLABreachedEvent, SLARecoveredEvent:
+		return fmt.Sprintf("%s/%s/sla/%s", p.appName, p.cluster, n.Trigger)
+	}
+	return fmt.Sprintf("%s/%s/backup/%s/%s", p.appName, p.cluster, n.Trigger, n.Destination)
+}
+
+func (p *PagerDutyNotifier) setOpen(key string, open bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.open[key] = open
+}
+
+func (p *PagerDutyNotifier) send(event pagerDutyEvent) error {
+	if err := postWebhook(p.client, p.eventsUrl, event); err != nil {
+		return fmt.Errorf("PagerDutyNotifier: %s %s: %w", event.EventAction, event.DedupKey, err)
+	}
+	return nil
+}
+
+func pagerDutySeverity(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "critical"
+	case SeverityWarning:
+		return "warning"
+	}
+	return "info"
+}
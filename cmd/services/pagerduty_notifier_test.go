@@ -0,0 +1,115 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type pagerDutyRecorder struct {
+	mu     sync.Mutex
+	events []pagerDutyEvent
+}
+
+func (p *pagerDutyRecorder) handler(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event := pagerDutyEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid event body: %v", err)
+		}
+		p.mu.Lock()
+		p.events = append(p.events, event)
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (p *pagerDutyRecorder) received() []pagerDutyEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]pagerDutyEvent{}, p.events...)
+}
+
+func TestPagerDutyNotifierTriggersAndResolves(t *testing.T) {
+	recorder := &pagerDutyRecorder{}
+	server := httptest.NewServer(recorder.handler(t))
+	defer server.Close()
+
+	p := GetPagerDutyNotifier(server.URL, "routing-key", "vault_backup", "prod")
+
+	failed := Notification{
+		Event:       BackupFailedEvent,
+		Severity:    SeverityCritical,
+		Subject:     "vault_backup error while creating backup",
+		Trigger:     "scheduled",
+		Destination: "google_drive:folder",
+		Error:       "snapshot failed",
+	}
+	succeeded := Notification{
+		Event:       BackupSucceededEvent,
+		Severity:    SeverityInfo,
+		Trigger:     "scheduled",
+		Destination: "google_drive:folder",
+	}
+
+	for _, n := range []Notification{failed, succeeded, succeeded} {
+		if err := p.Notify(n); err != nil {
+			t.Fatalf("Notify(%s) = %v", n.Event, err)
+		}
+	}
+
+	events := recorder.received()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want trigger and resolve: %+v", len(events), events)
+	}
+	trigger, resolve := events[0], events[1]
+	if trigger.EventAction != pagerDutyTrigger || resolve.EventAction != pagerDutyResolve {
+		t.Errorf("got actions %s, %s, want trigger, resolve", trigger.EventAction, resolve.EventAction)
+	}
+	if trigger.DedupKey == "" || trigger.DedupKey != resolve.DedupKey {
+		t.Errorf("dedup keys differ: %q, %q", trigger.DedupKey, resolve.DedupKey)
+	}
+	if trigger.RoutingKey != "routing-key" || trigger.Payload == nil || trigger.Payload.Severity != "critical" {
+		t.Errorf("unexpected trigger %+v", trigger)
+	}
+	if resolve.Payload != nil {
+		t.Errorf("resolve carries a payload %+v", resolve.Payload)
+	}
+}
+
+func TestPagerDutyNotifierIgnoresOtherEvents(t *testing.T) {
+	recorder := &pagerDutyRecorder{}
+	server := httptest.NewServer(recorder.handler(t))
+	defer server.Close()
+
+	p := GetPagerDutyNotifier(server.URL, "routing-key", "vault_backup", "prod")
+	for _, event := range []NotificationEvent{RetentionFailedEvent, TokenReloginEvent, WebsocketDisconnectedEvent} {
+		if err := p.Notify(Notification{Event: event, Severity: SeverityWarning}); err != nil {
+			t.Fatalf("Notify(%s) = %v", event, err)
+		}
+	}
+
+	if events := recorder.received(); len(events) != 0 {
+		t.Errorf("got %d events for non-incident notifications: %+v", len(events), events)
+	}
+}
+
+func TestPagerDutyNotifierSeparatesDestinations(t *testing.T) {
+	recorder := &pagerDutyRecorder{}
+	server := httptest.NewServer(recorder.handler(t))
+	defer server.Close()
+
+	p := GetPagerDutyNotifier(server.URL, "routing-key", "vault_backup", "prod")
+	p.Notify(Notification{Event: BackupFailedEvent, Severity: SeverityCritical, Trigger: "scheduled", Destination: "google_drive:a"})
+	p.Notify(Notification{Event: BackupFailedEvent, Severity: SeverityCritical, Trigger: "scheduled", Destination: "google_drive:b"})
+
+	events := recorder.received()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].DedupKey == events[1].DedupKey {
+		t.Errorf("destinations share dedup key %q", events[0].DedupKey)
+	}
+}
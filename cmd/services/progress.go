@@ -0,0 +1,106 @@
+package services
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	progressSubscriberBuffer = 64
+	progressPublishInterval  = time.Second
+)
+
+type ProgressEventType string
+
+const (
+	SnapshotStarted   ProgressEventType = "snapshot_started"
+	SnapshotProgress  ProgressEventType = "snapshot_progress"
+	SnapshotCompleted ProgressEventType = "snapshot_completed"
+	UploadProgress    ProgressEventType = "upload_progress"
+	UploadCompleted   ProgressEventType = "upload_completed"
+	BackupVerified    ProgressEventType = "verification"
+	RetentionDeleted  ProgressEventType = "retention_deleted"
+	JobCompleted      ProgressEventType = "job_completed"
+)
+
+type ProgressEvent struct {
+	Type       ProgressEventType `json:"type"`
+	Time       time.Time         `json:"time"`
+	JobId      string            `json:"job_id,omitempty"`
+	FileName   string            `json:"file_name,omitempty"`
+	FileId     string            `json:"file_id,omitempty"`
+	Bytes      int64             `json:"bytes,omitempty"`
+	TotalBytes int64             `json:"total_bytes,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// ProgressHub fans job events out to any number of subscribers. Slow
+// subscribers miss events rather than stalling the backup.
+type ProgressHub struct {
+	mu          sync.Mutex
+	subscribers map[chan ProgressEvent]struct{}
+}
+
+func GetProgressHub() *ProgressHub {
+	return &ProgressHub{subscribers: make(map[chan ProgressEvent]struct{})}
+}
+
+func (h *ProgressHub) Publish(e ProgressEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		select {
+		case sub <- e:
+		default:
+		}
+	}
+}
+
+func (h *ProgressHub) Subscribe() (<-chan ProgressEvent, func()) {
+	sub := make(chan ProgressEvent, progressSubscriberBuffer)
+
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[sub]; ok {
+			delete(h.subscribers, sub)
+			close(sub)
+		}
+	}
+	return sub, unsubscribe
+}
+
+// progressWriter counts bytes written through it and reports the running
+// total at most once per progressPublishInterval.
+type progressWriter struct {
+	w           io.Writer
+	onProgress  func(written int64)
+	written     int64
+	lastPublish time.Time
+}
+
+func newProgressWriter(w io.Writer, onProgress func(written int64)) *progressWriter {
+	return &progressWriter{w: w, onProgress: onProgress, lastPublish: time.Now()}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+
+	if pw.onProgress != nil && time.Since(pw.lastPublish) >= progressPublishInterval {
+		pw.lastPublish = time.Now()
+		pw.onProgress(pw.written)
+	}
+	return n, err
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookPayloadVersion    = "1"
+	webhookSignatureHeader   = "X-Vault-Backup-Signature"
+	webhookTimestampHeader   = "X-Vault-Backup-Timestamp"
+	defaultWebhookMaxRetries = 3
+	webhookInitialBackoff    = time.Second
+)
+
+type webhookPayload struct {
+	Version     string         `json:"version"`
+	EventType   string         `json:"event_type"`
+	Severity    string         `json:"severity"`
+	App         string         `json:"app"`
+	Cluster     string         `json:"cluster"`
+	JobId       string         `json:"job_id,omitempty"`
+	Trigger     string         `json:"trigger,omitempty"`
+	Destination string         `json:"destination,omitempty"`
+	Subject     string         `json:"subject"`
+	Message     string         `json:"message"`
+	Error       string         `json:"error,omitempty"`
+	Timings     webhookTimings `json:"timings"`
+}
+
+type webhookTimings struct {
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds,omitempty"`
+	SentAt          time.Time  `json:"sent_at"`
+}
+
+// SignedWebhookNotifier POSTs a versioned JSON document signed with
+// HMAC-SHA256 over "<timestamp>.<body>", retrying with exponential backoff.
+// The key is read once at startup, rotating it requires a restart.
+type SignedWebhookNotifier struct {
+	webhookUrl string
+	hmacKey    []byte
+	appName    string
+	cluster    string
+	maxRetries int
+	client     *http.Client
+}
+
+func GetSignedWebhookNotifier(webhookUrl, hmacKey, appName, cluster string, maxRetries int) *SignedWebhookNotifier {
+	return &SignedWebhookNotifier{
+		webhookUrl: webhookUrl,
+		hmacKey:    []byte(hmacKey),
+		appName:    appName,
+		cluster:    cluster,
+		maxRetries: maxRetries,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (s *SignedWebhookNotifier) Notify(notification Notification) error {
+	body, err := json.Marshal(s.payload(notification))
+	if err != nil {
+		return fmt.Errorf("SignedWebhookNotifier: unable to encode payload %w", err)
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.send(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.maxRetries {
+			return fmt.Errorf("SignedWebhookNotifier: giving up after %d attempts %w", attempt+1, err)
+		}
+
+		log.Printf("Warning: SignedWebhookNotifier - attempt %d failed, retrying in %s: %v", attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *SignedWebhookNotifier) payload(n Notification) webhookPayload {
+	p := webhookPayload{
+		Version:     webhookPayloadVersion,
+		EventType:   string(n.Event),
+		Severity:    string(n.Severity),
+		App:         s.appName,
+		Cluster:     s.cluster,
+		JobId:       n.JobId,
+		Trigger:     n.Trigger,
+		Destination: n.Destination,
+		Subject:     n.Subject,
+		Message:     n.Body,
+		Error:       n.Error,
+		Timings:     webhookTimings{SentAt: time.Now().UTC()},
+	}
+
+	if !n.StartedAt.IsZero() {
+		p.Timings.StartedAt = &n.StartedAt
+	}
+	if !n.FinishedAt.IsZero() {
+		p.Timings.FinishedAt = &n.FinishedAt
+	}
+	if !n.StartedAt.IsZero() && !n.FinishedAt.IsZero() {
+		p.Timings.DurationSeconds = n.FinishedAt.Sub(n.StartedAt).Seconds()
+	}
+	return p
+}
+
+// send delivers one attempt and reports whether a failure is worth retrying.
+func (s *SignedWebhookNotifier) send(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("invalid webhook url %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+s.sign(timestamp, body))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("error while calling webhook %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook responded with %s: %s", res.Status, msg)
+	}
+	return false, nil
+}
+
+func (s *SignedWebhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, s.hmacKey)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
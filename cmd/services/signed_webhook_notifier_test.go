@@ -0,0 +1,81 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSignedWebhookNotifierSignsPayload(t *testing.T) {
+	var body []byte
+	var timestamp, signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		timestamp = r.Header.Get(webhookTimestampHeader)
+		signature = r.Header.Get(webhookSignatureHeader)
+	}))
+	defer server.Close()
+
+	s := GetSignedWebhookNotifier(server.URL, "secret", "vault_backup", "prod", 0)
+	err := s.Notify(Notification{Event: BackupFailedEvent, Severity: SeverityCritical, Subject: "failed", JobId: "job-1"})
+	if err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	payload := webhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if payload.Version != webhookPayloadVersion || payload.EventType != string(BackupFailedEvent) ||
+		payload.App != "vault_backup" || payload.Cluster != "prod" || payload.JobId != "job-1" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestSignedWebhookNotifierRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	s := GetSignedWebhookNotifier(server.URL, "secret", "vault_backup", "prod", 2)
+	if err := s.Notify(Notification{Event: SLARecoveredEvent, Severity: SeverityInfo}); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("got %d calls, want 2", got)
+	}
+}
+
+func TestSignedWebhookNotifierDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	s := GetSignedWebhookNotifier(server.URL, "secret", "vault_backup", "prod", 3)
+	if err := s.Notify(Notification{Event: BackupFailedEvent, Severity: SeverityCritical}); err == nil {
+		t.Fatal("Notify() succeeded on 401")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("got %d calls, want 1", got)
+	}
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	smtpTLSImplicit          = "tls"
+	smtpTLSStartTLSRequired  = "starttls"
+	smtpTLSStartTLSOptional  = "starttls_optional"
+	smtpTLSNone              = "none"
+	smtpAuthPlain            = "plain"
+	smtpAuthLogin            = "login"
+	smtpAuthCRAMMD5          = "cram-md5"
+	smtpAuthXOAUTH2          = "xoauth2"
+	smtpAuthNone             = "none"
+	defaultSMTPTimeout       = 30 * time.Second
+	defaultSMTPTLSMode       = smtpTLSStartTLSOptional
+	defaultSMTPAuthMechanism = smtpAuthPlain
+)
+
+type SMTPTransportOptions struct {
+	Host          string
+	Port          string
+	TLSMode       string
+	CAFile        string
+	Timeout       string
+	AuthMechanism string
+	Username      string
+	// Password holds the OAuth2 access token for the xoauth2 mechanism
+	Password string
+	// AccessToken, when set, returns the current OAuth2 access token for the
+	// xoauth2 mechanism on every send, so tokens refreshed elsewhere are used
+	AccessToken func() (string, error)
+}
+
+// smtpTransport delivers a message over a single SMTP session using the
+// configured TLS mode and authentication mechanism.
+type smtpTransport struct {
+	host      string
+	address   string
+	tlsMode   string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	auth      smtp.Auth
+}
+
+func newSMTPTransport(opts SMTPTransportOptions) (*smtpTransport, error) {
+	t := &smtpTransport{
+		host:      opts.Host,
+		address:   net.JoinHostPort(opts.Host, opts.Port),
+		tlsMode:   opts.TLSMode,
+		tlsConfig: &tls.Config{ServerName: opts.Host, MinVersion: tls.VersionTLS12},
+		timeout:   defaultSMTPTimeout,
+	}
+	if t.tlsMode == "" {
+		t.tlsMode = defaultSMTPTLSMode
+	}
+
+	switch t.tlsMode {
+	case smtpTLSImplicit, smtpTLSStartTLSRequired, smtpTLSStartTLSOptional, smtpTLSNone:
+	default:
+		return nil, fmt.Errorf("newSMTPTransport: unknown tls mode %q", t.tlsMode)
+	}
+
+	if opts.Timeout != "" {
+		timeout, err := time.ParseDuration(opts.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("newSMTPTransport: invalid timeout %w", err)
+		}
+		t.timeout = timeout
+	}
+
+	if opts.CAFile != "" {
+		caPEM, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("newSMTPTransport: unable to read CA bundle %s, %w", opts.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("newSMTPTransport: no certificates found in %s", opts.CAFile)
+		}
+		t.tlsConfig.RootCAs = pool
+	}
+
+	mechanism := opts.AuthMechanism
+	if mechanism == "" {
+		mechanism = defaultSMTPAuthMechanism
+	}
+	switch mechanism {
+	case smtpAuthPlain:
+		t.auth = smtp.PlainAuth("", opts.Username, opts.Password, opts.Host)
+	case smtpAuthLogin:
+		t.auth = &loginAuth{username: opts.Username, password: opts.Password, host: opts.Host}
+	case smtpAuthCRAMMD5:
+		t.auth = smtp.CRAMMD5Auth(opts.Username, opts.Password)
+	case smtpAuthXOAUTH2:
+		accessToken := opts.AccessToken
+		if accessToken == nil {
+			password := opts.Password
+			accessToken = func() (string, error) { return password, nil }
+		}
+		t.auth = &xoauth2Auth{username: opts.Username, accessToken: accessToken, host: opts.Host}
+	case smtpAuthNone:
+	default:
+		return nil, fmt.Errorf("newSMTPTransport: unknown auth mechanism %q", mechanism)
+	}
+
+	return t, nil
+}
+
+func (t *smtpTransport) send(from string, to []string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: t.timeout}
+
+	var conn net.Conn
+	var err error
+	if t.tlsMode == smtpTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", t.address, t.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", t.address)
+	}
+	if err != nil {
+		return fmt.Errorf("send: unable to connect to %s %w", t.address, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		conn.Close()
+		return fmt.Errorf("send: %w", err)
+	}
+
+	c, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("send: smtp handshake with %s failed %w", t.address, err)
+	}
+	defer c.Close()
+
+	if t.tlsMode == smtpTLSStartTLSRequired || t.tlsMode == smtpTLSStartTLSOptional {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(t.tlsConfig); err != nil {
+				return fmt.Errorf("send: STARTTLS failed %w", err)
+			}
+		} else if t.tlsMode == smtpTLSStartTLSRequired {
+			return fmt.Errorf("send: %s does not support STARTTLS", t.address)
+		}
+	}
+
+	if t.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("send: %s does not support AUTH", t.address)
+		}
+		if err := c.Auth(t.auth); err != nil {
+			return fmt.Errorf("send: authentication failed %w", err)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return fmt.Errorf("send: MAIL FROM rejected %w", err)
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("send: RCPT TO %s rejected %w", rcpt, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("send: DATA rejected %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("send: error while writing message %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("send: message rejected %w", err)
+	}
+
+	return c.Quit()
+}
+
+// requireTLS mirrors smtp.PlainAuth: credentials are only sent over TLS or
+// to localhost.
+func requireTLS(server *smtp.ServerInfo, host string) error {
+	if server.TLS || host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		if server.Name != host {
+			return errors.New("wrong host name")
+		}
+		return nil
+	}
+	return errors.New("unencrypted connection")
+}
+
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := requireTLS(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN prompt %q", fromServer)
+	}
+}
+
+type xoauth2Auth struct {
+	username    string
+	accessToken func() (string, error)
+	host        string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := requireTLS(server, a.host); err != nil {
+		return "", nil, err
+	}
+	token, err := a.accessToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to obtain OAuth2 access token %w", err)
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+// Next answers the JSON error challenge with an empty response so the server
+// completes the exchange with its final error code.
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return []byte{}, nil
+	}
+	return nil, nil
+}
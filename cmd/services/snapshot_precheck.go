@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	vault "github.com/hashicorp/vault/api"
+	"log"
+	"sort"
+)
+
+// errSnapshotDeferred marks pre-check failures that are expected to clear up
+// on their own, such as a sealed node or a leader election in progress.
+var errSnapshotDeferred = errors.New("snapshot deferred")
+
+// NodeInfo describes the Vault node a snapshot was taken from and the raft
+// cluster around it.
+type NodeInfo struct {
+	Address          string       `json:"address"`
+	ClusterName      string       `json:"cluster_name,omitempty"`
+	ClusterId        string       `json:"cluster_id,omitempty"`
+	Version          string       `json:"version,omitempty"`
+	RedirectedFrom   string       `json:"redirected_from,omitempty"`
+	Leader           string       `json:"leader,omitempty"`
+	AutopilotHealthy *bool        `json:"autopilot_healthy,omitempty"`
+	FailureTolerance int          `json:"failure_tolerance,omitempty"`
+	RaftServers      []RaftServer `json:"raft_servers,omitempty"`
+	RaftPeers        []RaftPeer   `json:"raft_configuration,omitempty"`
+}
+
+type RaftServer struct {
+	Id        string `json:"id"`
+	Address   string `json:"address"`
+	Status    string `json:"status"`
+	Healthy   bool   `json:"healthy"`
+	LastIndex uint64 `json:"last_index"`
+	Version   string `json:"version,omitempty"`
+}
+
+// RaftPeer is a member of the raft configuration as reported by
+// sys/storage/raft/configuration.
+type RaftPeer struct {
+	NodeId  string `json:"node_id"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+	Voter   bool   `json:"voter"`
+}
+
+// SnapshotSource is the client a snapshot is taken with, pointing at the
+// active node.
+type SnapshotSource struct {
+	client *vault.Client
+	Node   NodeInfo
+}
+
+// SnapshotPreCheck checks sys/health and the autopilot state before a
+// snapshot. A standby node is bypassed by talking to the active node
+// directly, since the snapshot endpoint does not follow redirects.
+func (v *Vault) SnapshotPreCheck(ctx context.Context, requireHealthyAutopilot bool) (*SnapshotSource, error) {
+	health, err := v.client.Sys().HealthWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: health check failed %v", errSnapshotDeferred, err)
+	}
+	if !health.Initialized {
+		return nil, fmt.Errorf("SnapshotPreCheck: Vault at %s is not initialized", v.client.Address())
+	}
+	if health.Sealed {
+		return nil, fmt.Errorf("%w: Vault at %s is sealed", errSnapshotDeferred, v.client.Address())
+	}
+
+	source := &SnapshotSource{
+		client: v.client,
+		Node: NodeInfo{
+			Address:     v.client.Address(),
+			ClusterName: health.ClusterName,
+			ClusterId:   health.ClusterID,
+			Version:     health.Version,
+		},
+	}
+
+	if health.Standby {
+		leader, err := v.client.Sys().LeaderWithContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: standby node, unable to find the active node %v", errSnapshotDeferred, err)
+		}
+		if leader.LeaderAddress == "" {
+			return nil, fmt.Errorf("%w: standby node and no active node elected", errSnapshotDeferred)
+		}
+
+		client, err := v.client.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("SnapshotPreCheck: unable to clone Vault client %w", err)
+		}
+		if err := client.SetAddress(leader.LeaderAddress); err != nil {
+			return nil, fmt.Errorf("SnapshotPreCheck: invalid active node address %s %w", leader.LeaderAddress, err)
+		}
+		client.SetToken(v.tokens.Token())
+
+		log.Printf("SnapshotPreCheck: %s is a standby, taking the snapshot from %s", v.client.Address(), leader.LeaderAddress)
+		source.client = client
+		source.Node.RedirectedFrom = source.Node.Address
+		source.Node.Address = leader.LeaderAddress
+	}
+
+	peers, err := raftConfiguration(ctx, source.client)
+	if err != nil {
+		log.Printf("SnapshotPreCheck: raft configuration unavailable %v", err)
+	}
+	source.Node.RaftPeers = peers
+
+	state, err := source.client.Sys().RaftAutopilotStateWithContext(ctx)
+	if err != nil || state == nil {
+		// not integrated storage or no permission, the snapshot call decides
+		log.Printf("SnapshotPreCheck: autopilot state unavailable %v", err)
+		return source, nil
+	}
+
+	healthy := state.Healthy
+	source.Node.Leader = state.Leader
+	source.Node.AutopilotHealthy = &healthy
+	source.Node.FailureTolerance = state.FailureTolerance
+	for _, server := range state.Servers {
+		source.Node.RaftServers = append(source.Node.RaftServers, RaftServer{
+			Id:        server.ID,
+			Address:   server.Address,
+			Status:    server.Status,
+			Healthy:   server.Healthy,
+			LastIndex: server.LastIndex,
+			Version:   server.Version,
+		})
+	}
+	sort.Slice(source.Node.RaftServers, func(i, j int) bool {
+		return source.Node.RaftServers[i].Id < source.Node.RaftServers[j].Id
+	})
+
+	if requireHealthyAutopilot && !state.Healthy {
+		return nil, fmt.Errorf("%w: autopilot reports the cluster unhealthy (failure tolerance %d)",
+			errSnapshotDeferred, state.FailureTolerance)
+	}
+	return source, nil
+}
+
+// raftConfiguration reads the peers of the raft cluster, sorted by node id.
+func raftConfiguration(ctx context.Context, client *vault.Client) ([]RaftPeer, error) {
+	secret, err := client.Logical().ReadWithContext(ctx, "sys/storage/raft/configuration")
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data["config"] == nil {
+		return nil, fmt.Errorf("empty response")
+	}
+
+	b, err := json.Marshal(secret.Data["config"])
+	if err != nil {
+		return nil, err
+	}
+	var configuration struct {
+		Servers []RaftPeer `json:"servers"`
+	}
+	if err := json.Unmarshal(b, &configuration); err != nil {
+		return nil, fmt.Errorf("unable to parse raft configuration %w", err)
+	}
+
+	sort.Slice(configuration.Servers, func(i, j int) bool {
+		return configuration.Servers[i].NodeId < configuration.Servers[j].NodeId
+	})
+	return configuration.Servers, nil
+}
@@ -1,8 +1,28 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	vaultTokenHeader               = "X-Vault-Token"
+	defaultStatusServerPort        = "8080"
+	defaultStatusServerBindAddress = "127.0.0.1"
+	progressWriteTimeout           = 10 * time.Second
+	progressAuthTimeout            = 10 * time.Second
 )
 
 type AppStatusProvider interface {
@@ -41,22 +61,131 @@ type BackupInfo struct {
 }
 
 type FileAppStatus struct {
+	mu                   sync.Mutex
 	statusFilePath       string
 	startTime            time.Time
 	lastFailedBackup     LastFailedBackup
 	lastSuccessfulBackup LastSuccessfulBackup
+	lastSuccessByTrigger map[string]time.Time
+}
+
+type fileAppStatusDocument struct {
+	StartTime            time.Time            `json:"start_time"`
+	LastSuccessTime      time.Time            `json:"last_success_time,omitempty"`
+	LastSuccessStatus    string               `json:"last_success_status,omitempty"`
+	LastFailureTime      time.Time            `json:"last_failure_time,omitempty"`
+	LastFailureStatus    string               `json:"last_failure_status,omitempty"`
+	LastSuccessByTrigger map[string]time.Time `json:"last_success_by_trigger"`
 }
 
 func GetFileAppStatus(statusFile string, appStartTime time.Time) (*FileAppStatus, error) {
-	return &FileAppStatus{}, nil
+	fs := &FileAppStatus{
+		statusFilePath:       statusFile,
+		startTime:            appStartTime,
+		lastSuccessByTrigger: make(map[string]time.Time),
+	}
+
+	if err := fs.load(); err != nil {
+		return nil, fmt.Errorf("GetFileAppStatus: %w", err)
+	}
+	return fs, nil
+}
+
+// RecordBackup stores the outcome of a backup for the given trigger type and
+// persists it immediately so it survives restarts.
+func (fs *FileAppStatus) RecordBackup(trigger string, backupErr error) {
+	fs.mu.Lock()
+	now := time.Now()
+	if backupErr != nil {
+		fs.lastFailedBackup = LastFailedBackup{time: now, status: backupErr.Error()}
+	} else {
+		fs.lastSuccessfulBackup = LastSuccessfulBackup{time: now, status: string(JobSucceeded)}
+		fs.lastSuccessByTrigger[trigger] = now
+	}
+	fs.mu.Unlock()
+
+	fs.SaveStatusToFile()
+}
+
+func (fs *FileAppStatus) LastSuccess(trigger string) (time.Time, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	t, ok := fs.lastSuccessByTrigger[trigger]
+	return t, ok
+}
+
+func (fs *FileAppStatus) StartTime() time.Time {
+	return fs.startTime
 }
 
 func (fs *FileAppStatus) SaveStatusToFile() {
+	if fs.statusFilePath == "" {
+		return
+	}
+
+	fs.mu.Lock()
+	doc := fileAppStatusDocument{
+		StartTime:            fs.startTime,
+		LastSuccessTime:      fs.lastSuccessfulBackup.time,
+		LastSuccessStatus:    fs.lastSuccessfulBackup.status,
+		LastFailureTime:      fs.lastFailedBackup.time,
+		LastFailureStatus:    fs.lastFailedBackup.status,
+		LastSuccessByTrigger: make(map[string]time.Time, len(fs.lastSuccessByTrigger)),
+	}
+	for trigger, t := range fs.lastSuccessByTrigger {
+		doc.LastSuccessByTrigger[trigger] = t
+	}
+	fs.mu.Unlock()
 
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Printf("SaveStatusToFile: error while encoding status %v", err)
+		return
+	}
+
+	tmpPath := fs.statusFilePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		log.Printf("SaveStatusToFile: error while writing %s %v", tmpPath, err)
+		return
+	}
+	if err := os.Rename(tmpPath, fs.statusFilePath); err != nil {
+		log.Printf("SaveStatusToFile: error while replacing %s %v", fs.statusFilePath, err)
+	}
 }
 
 func (fs *FileAppStatus) LoadStatusFromFile() {
+	if err := fs.load(); err != nil {
+		log.Printf("LoadStatusFromFile: %v", err)
+	}
+}
+
+func (fs *FileAppStatus) load() error {
+	if fs.statusFilePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(fs.statusFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read status file %s, %w", fs.statusFilePath, err)
+	}
 
+	doc := fileAppStatusDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("unable to parse status file %s, %w", fs.statusFilePath, err)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.lastSuccessfulBackup = LastSuccessfulBackup{time: doc.LastSuccessTime, status: doc.LastSuccessStatus}
+	fs.lastFailedBackup = LastFailedBackup{time: doc.LastFailureTime, status: doc.LastFailureStatus}
+	for trigger, t := range doc.LastSuccessByTrigger {
+		fs.lastSuccessByTrigger[trigger] = t
+	}
+	return nil
 }
 
 type DbAppStatus struct {
@@ -75,9 +204,323 @@ func (db *DbAppStatus) LoadStatusFromDb() {
 }
 
 type StatusServer struct {
-	server http.Server
+	server          http.Server
+	vault           *Vault
+	backupScheduler *BackupScheduler
+	watchdog        *BackupWatchdog
+	config          *config.AppConfig
+	upgrader        websocket.Upgrader
+}
+
+type triggerBackupRequest struct {
+	FolderId string `json:"folder_id"`
+}
+
+type cancelJobRequest struct {
+	JobId string `json:"job_id"`
+}
+
+func GetStatusServer(
+	appConfig *config.AppConfig,
+	vault *Vault,
+	backupScheduler *BackupScheduler,
+	watchdog *BackupWatchdog) *StatusServer {
+	s := &StatusServer{
+		vault:           vault,
+		backupScheduler: backupScheduler,
+		watchdog:        watchdog,
+		config:          appConfig,
+	}
+	s.upgrader.CheckOrigin = s.checkProgressOrigin
+	return s
+}
+
+// StartServer binds the status server and serves it in the background. Bind
+// errors and incomplete admin settings fail startup.
+func (s *StatusServer) StartServer(ctx context.Context, port string) error {
+	if port == "" {
+		port = defaultStatusServerPort
+	}
+	bindAddress := s.config.StatusServer.BindAddress
+	if bindAddress == "" {
+		bindAddress = defaultStatusServerBindAddress
+	}
+	if s.config.StatusServer.AdminCapabilityPath == "" || s.config.StatusServer.AdminCapability == "" {
+		return fmt.Errorf("StartServer: status_server.admin_capability_path and admin_capability are required")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/backups/trigger", s.adminOnly(s.triggerBackupHandler))
+	mux.HandleFunc("/api/backups/jobs", s.adminOnly(s.listJobsHandler))
+	mux.HandleFunc("/api/backups/cancel", s.adminOnly(s.cancelJobHandler))
+	mux.HandleFunc("/api/backups/verify-latest", s.adminOnly(s.verifyLatestHandler))
+	mux.HandleFunc("/api/status", s.statusHandler)
+	mux.HandleFunc("/ws/progress", s.progressHandler)
+	mux.HandleFunc("/metrics", s.metricsHandler)
+	mux.Handle("/", dashboardHandler())
+
+	s.server.Addr = net.JoinHostPort(bindAddress, port)
+	s.server.Handler = mux
+	s.server.ReadHeaderTimeout = 10 * time.Second
+
+	tlsConfig := s.config.StatusServer.TLS
+	if tlsConfig.Enabled {
+		serverTLS, err := buildStatusServerTLSConfig(ctx, tlsConfig, s.vault)
+		if err != nil {
+			return fmt.Errorf("StartServer: %w", err)
+		}
+		s.server.TLSConfig = serverTLS
+	}
+
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("StartServer: unable to listen on %s %w", s.server.Addr, err)
+	}
+
+	go func() {
+		var err error
+		if tlsConfig.Enabled {
+			log.Printf("Status server listening on %s (TLS)", s.server.Addr)
+			err = s.server.ServeTLS(listener, "", "")
+		} else {
+			log.Printf("Status server listening on %s", s.server.Addr)
+			err = s.server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("StartServer: status server stopped %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// adminOnly accepts only POST requests carrying a Vault token that holds
+// the configured capability.
+func (s *StatusServer) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !s.authorize(w, r) {
+			return
+		}
+
+		next(w, r)
+	}
 }
 
-func (s *StatusServer) StartServer(port string) {
+// authorize checks the request's Vault token against the configured admin
+// capability and writes the error response when it is missing or lacks it.
+func (s *StatusServer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	token := r.Header.Get(vaultTokenHeader)
+	if token == "" {
+		writeJSONError(w, http.StatusUnauthorized, "missing "+vaultTokenHeader+" header")
+		return false
+	}
+
+	if err := s.validateToken(r.Context(), token); err != nil {
+		log.Printf("authorize: rejected request to %s: %v", r.URL.Path, err)
+		writeJSONError(w, http.StatusForbidden, "permission denied")
+		return false
+	}
+	return true
+}
+
+func (s *StatusServer) validateToken(ctx context.Context, token string) error {
+	return s.vault.ValidateAdminToken(ctx, token,
+		s.config.StatusServer.AdminCapabilityPath,
+		s.config.StatusServer.AdminCapability)
+}
+
+func (s *StatusServer) triggerBackupHandler(w http.ResponseWriter, r *http.Request) {
+	req := triggerBackupRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	if req.FolderId == "" {
+		req.FolderId = s.config.GoogleDriveConfig.OnEventDeployFolderId
+	}
+
+	job, err := s.backupScheduler.TriggerBackup(ManualEvent, req.FolderId)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *StatusServer) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.backupScheduler.Jobs().List())
+}
+
+func (s *StatusServer) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	req := cancelJobRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JobId == "" {
+		writeJSONError(w, http.StatusBadRequest, "job_id is required")
+		return
+	}
+
+	if err := s.backupScheduler.Jobs().Cancel(req.JobId); err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"job_id": req.JobId, "status": string(JobCancelled)})
+}
+
+func (s *StatusServer) verifyLatestHandler(w http.ResponseWriter, r *http.Request) {
+	job, err := s.backupScheduler.VerifyLatestBackup(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"job_id": job.Id, "file_id": job.RemoteFileId, "status": "verified"})
+}
+
+func (s *StatusServer) statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	// jobs carry Drive ids, errors and secret paths, same check as the admin API
+	if !s.authorize(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backupScheduler.Status())
+}
+
+// metricsHandler exposes watchdog and token state in the Prometheus text format.
+func (s *StatusServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	states := s.watchdog.State()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP vault_backup_sla_breached Whether no backup succeeded within the SLA window.")
+	fmt.Fprintln(w, "# TYPE vault_backup_sla_breached gauge")
+	for _, state := range states {
+		breached := 0
+		if state.Breached {
+			breached = 1
+		}
+		fmt.Fprintf(w, "vault_backup_sla_breached{trigger=%q} %d\n", state.Trigger, breached)
+	}
+
+	fmt.Fprintln(w, "# HELP vault_backup_last_success_timestamp_seconds Time of the last successful backup.")
+	fmt.Fprintln(w, "# TYPE vault_backup_last_success_timestamp_seconds gauge")
+	for _, state := range states {
+		if state.LastSuccess != nil {
+			fmt.Fprintf(w, "vault_backup_last_success_timestamp_seconds{trigger=%q} %d\n", state.Trigger, state.LastSuccess.Unix())
+		}
+	}
+
+	token := s.vault.Tokens().State()
+	healthy := 0
+	if token.Healthy {
+		healthy = 1
+	}
+	fmt.Fprintln(w, "# HELP vault_backup_token_healthy Whether the Vault auth token is valid.")
+	fmt.Fprintln(w, "# TYPE vault_backup_token_healthy gauge")
+	fmt.Fprintf(w, "vault_backup_token_healthy %d\n", healthy)
+	fmt.Fprintln(w, "# HELP vault_backup_token_login_failures Consecutive failed Vault logins.")
+	fmt.Fprintln(w, "# TYPE vault_backup_token_login_failures gauge")
+	fmt.Fprintf(w, "vault_backup_token_login_failures %d\n", token.ConsecutiveFailures)
+}
+
+// progressHandler streams job progress events to a websocket client until
+// the client disconnects. Clients authenticate like the admin API, either
+// with the X-Vault-Token header or, since browsers cannot set headers on
+// websockets, by sending the token as the first message.
+func (s *StatusServer) progressHandler(w http.ResponseWriter, r *http.Request) {
+	headerToken := r.Header.Get(vaultTokenHeader) != ""
+	if headerToken && !s.authorize(w, r) {
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("progressHandler: websocket upgrade failed %v", err)
+		return
+	}
+	defer conn.Close()
+
+	if !headerToken {
+		if err := s.authorizeProgress(r.Context(), conn); err != nil {
+			log.Printf("progressHandler: rejected websocket client %v", err)
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "permission denied"),
+				time.Now().Add(progressWriteTimeout))
+			return
+		}
+	}
+
+	events, unsubscribe := s.backupScheduler.Progress().Subscribe()
+	defer unsubscribe()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-events:
+			conn.SetWriteDeadline(time.Now().Add(progressWriteTimeout))
+			if err := conn.WriteJSON(e); err != nil {
+				log.Printf("progressHandler: websocket write failed %v", err)
+				return
+			}
+		}
+	}
+}
+
+// authorizeProgress reads the token the client sends as its first message.
+func (s *StatusServer) authorizeProgress(ctx context.Context, conn *websocket.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(progressAuthTimeout))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		return fmt.Errorf("authorizeProgress: no token received %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	token := strings.TrimSpace(string(message))
+	if token == "" {
+		return fmt.Errorf("authorizeProgress: empty token")
+	}
+	return s.validateToken(ctx, token)
+}
+
+func (s *StatusServer) checkProgressOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range s.config.StatusServer.ProgressAllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writeJSON: error while encoding response %v", err)
+	}
+}
 
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
 }
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const pkiRenewRetryInterval = time.Minute
+
+func buildStatusServerTLSConfig(ctx context.Context, tlsConfig config.StatusServerTLSConfig, vault *Vault) (*tls.Config, error) {
+	serverTLS := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if tlsConfig.VaultPKI.Mount != "" {
+		certs, err := getPKICertificateSource(ctx, vault, tlsConfig.VaultPKI)
+		if err != nil {
+			return nil, fmt.Errorf("buildStatusServerTLSConfig: %w", err)
+		}
+		serverTLS.GetCertificate = certs.getCertificate
+	} else {
+		cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("buildStatusServerTLSConfig: unable to load certificate %s, %w", tlsConfig.CertFile, err)
+		}
+		serverTLS.Certificates = []tls.Certificate{cert}
+	}
+
+	if tlsConfig.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("buildStatusServerTLSConfig: unable to read client CA bundle %s, %w", tlsConfig.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("buildStatusServerTLSConfig: no certificates found in %s", tlsConfig.ClientCAFile)
+		}
+		serverTLS.ClientCAs = pool
+		serverTLS.ClientAuth = tls.VerifyClientCertIfGiven
+		if tlsConfig.RequireClientCert {
+			serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if tlsConfig.RequireClientCert {
+		return nil, fmt.Errorf("buildStatusServerTLSConfig: require_client_cert needs client_ca_file")
+	}
+
+	return serverTLS, nil
+}
+
+// pkiCertificateSource keeps a certificate issued by Vault's PKI engine and
+// re-issues it once two thirds of its lifetime have passed.
+type pkiCertificateSource struct {
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	vault  *Vault
+	config config.VaultPKIConfig
+}
+
+func getPKICertificateSource(ctx context.Context, vault *Vault, pkiConfig config.VaultPKIConfig) (*pkiCertificateSource, error) {
+	source := &pkiCertificateSource{vault: vault, config: pkiConfig}
+	if err := source.issue(ctx); err != nil {
+		return nil, err
+	}
+
+	go source.renewPeriodically(ctx)
+	return source, nil
+}
+
+func (p *pkiCertificateSource) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cert, nil
+}
+
+func (p *pkiCertificateSource) issue(ctx context.Context) error {
+	cert, err := p.vault.IssueCertificate(ctx, p.config.Mount, p.config.Role, p.config.CommonName, p.config.AltNames, p.config.TTL)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.cert = cert
+	p.mu.Unlock()
+
+	log.Printf("Status server certificate issued from %s, valid until %s", p.config.Mount, cert.Leaf.NotAfter)
+	return nil
+}
+
+func (p *pkiCertificateSource) renewPeriodically(ctx context.Context) {
+	for {
+		p.mu.RLock()
+		leaf := p.cert.Leaf
+		p.mu.RUnlock()
+
+		renewAt := leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) * 2 / 3)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(renewAt)):
+		}
+
+		for {
+			err := p.issue(ctx)
+			if err == nil {
+				break
+			}
+			log.Printf("renewPeriodically: unable to renew status server certificate %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pkiRenewRetryInterval):
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	defaultTemplateName  = "default"
+	textTemplateSuffix   = ".txt.tmpl"
+	htmlTemplateSuffix   = ".html.tmpl"
+	subjectTemplateBlock = "subject"
+	bodyTemplateBlock    = "body"
+)
+
+//go:embed templates
+var defaultTemplateFiles embed.FS
+
+type notificationTemplateData struct {
+	Notification
+	AppName  string
+	Cluster  string
+	Duration time.Duration
+}
+
+// NotificationTemplates renders notifications with a text and an HTML
+// template per event type. An event's text template defines the "subject"
+// and "body" blocks shared by every channel, default.* is the email layout
+// around them. Templates in the override directory named
+// <event>.txt.tmpl / <event>.html.tmpl (or default.*) take precedence over
+// the embedded ones.
+type NotificationTemplates struct {
+	appName string
+	cluster string
+	text    map[string]*texttemplate.Template
+	html    map[string]*htmltemplate.Template
+}
+
+func GetNotificationTemplates(overrideDir, appName, cluster string) (*NotificationTemplates, error) {
+	t := &NotificationTemplates{
+		appName: appName,
+		cluster: cluster,
+		text:    make(map[string]*texttemplate.Template),
+		html:    make(map[string]*htmltemplate.Template),
+	}
+	textSources := make(map[string]string)
+	htmlSources := make(map[string]string)
+
+	embedded, err := fs.Sub(defaultTemplateFiles, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("GetNotificationTemplates: embedded templates are missing %w", err)
+	}
+	if err := readTemplates(embedded, textSources, htmlSources); err != nil {
+		return nil, fmt.Errorf("GetNotificationTemplates: embedded templates %w", err)
+	}
+
+	if overrideDir != "" {
+		if err := readTemplates(os.DirFS(overrideDir), textSources, htmlSources); err != nil {
+			return nil, fmt.Errorf("GetNotificationTemplates: templates in %s %w", overrideDir, err)
+		}
+	}
+
+	if err := t.parse(textSources, htmlSources); err != nil {
+		return nil, fmt.Errorf("GetNotificationTemplates: %w", err)
+	}
+	return t, nil
+}
+
+// Compose fills in the subject and body of a notification from its event
+// template, keeping those the sender already set.
+func (t *NotificationTemplates) Compose(n Notification) (Notification, error) {
+	textTmpl, ok := t.text[string(n.Event)]
+	if !ok {
+		textTmpl = t.text[defaultTemplateName]
+	}
+	data := t.data(n)
+
+	var err error
+	if n.Subject == "" {
+		n.Subject, err = executeBlock(textTmpl, subjectTemplateBlock, data)
+	}
+	if n.Body == "" && err == nil {
+		n.Body, err = executeBlock(textTmpl, bodyTemplateBlock, data)
+	}
+	if n.Subject == "" {
+		n.Subject = fmt.Sprintf("%s %s", t.appName, n.Event)
+	}
+	if err != nil {
+		return n, fmt.Errorf("Compose: template for %s %w", n.Event, err)
+	}
+	return n, nil
+}
+
+// Render returns subject, plain text and HTML body for the notification.
+func (t *NotificationTemplates) Render(n Notification) (string, string, string, error) {
+	n, err := t.Compose(n)
+	if err != nil {
+		return "", "", "", fmt.Errorf("Render: %w", err)
+	}
+	data := t.data(n)
+
+	textTmpl, ok := t.text[string(n.Event)]
+	if !ok {
+		textTmpl = t.text[defaultTemplateName]
+	}
+	htmlTmpl, ok := t.html[string(n.Event)]
+	if !ok {
+		htmlTmpl = t.html[defaultTemplateName]
+	}
+
+	text := bytes.Buffer{}
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return "", "", "", fmt.Errorf("Render: text template for %s %w", n.Event, err)
+	}
+
+	html := bytes.Buffer{}
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return "", "", "", fmt.Errorf("Render: html template for %s %w", n.Event, err)
+	}
+
+	return n.Subject, text.String(), html.String(), nil
+}
+
+func (t *NotificationTemplates) data(n Notification) notificationTemplateData {
+	data := notificationTemplateData{Notification: n, AppName: t.appName, Cluster: t.cluster}
+	if !n.StartedAt.IsZero() && !n.FinishedAt.IsZero() {
+		data.Duration = n.FinishedAt.Sub(n.StartedAt).Round(time.Millisecond)
+	}
+	return data
+}
+
+func executeBlock(tmpl *texttemplate.Template, name string, data notificationTemplateData) (string, error) {
+	if tmpl.Lookup(name) == nil {
+		return "", nil
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// parse builds every event template on top of the default layout, so an
+// event file only needs to define its blocks.
+func (t *NotificationTemplates) parse(textSources, htmlSources map[string]string) error {
+	textLayout, err := texttemplate.New(defaultTemplateName + textTemplateSuffix).Parse(textSources[defaultTemplateName])
+	if err != nil {
+		return fmt.Errorf("invalid template %s%s %w", defaultTemplateName, textTemplateSuffix, err)
+	}
+	htmlLayout, err := htmltemplate.New(defaultTemplateName + htmlTemplateSuffix).Parse(htmlSources[defaultTemplateName])
+	if err != nil {
+		return fmt.Errorf("invalid template %s%s %w", defaultTemplateName, htmlTemplateSuffix, err)
+	}
+	t.text[defaultTemplateName] = textLayout
+	t.html[defaultTemplateName] = htmlLayout
+
+	for name, content := range textSources {
+		if name == defaultTemplateName {
+			continue
+		}
+		tmpl := texttemplate.Must(textLayout.Clone())
+		if _, err := tmpl.Parse(content); err != nil {
+			return fmt.Errorf("invalid template %s%s %w", name, textTemplateSuffix, err)
+		}
+		t.text[name] = tmpl
+	}
+	for name, content := range htmlSources {
+		if name == defaultTemplateName {
+			continue
+		}
+		tmpl := htmltemplate.Must(htmlLayout.Clone())
+		if _, err := tmpl.Parse(content); err != nil {
+			return fmt.Errorf("invalid template %s%s %w", name, htmlTemplateSuffix, err)
+		}
+		t.html[name] = tmpl
+	}
+	return nil
+}
+
+func readTemplates(fsys fs.FS, textSources, htmlSources map[string]string) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("unable to read template directory %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+
+		var sources map[string]string
+		switch {
+		case strings.HasSuffix(name, textTemplateSuffix):
+			sources = textSources
+			name = strings.TrimSuffix(name, textTemplateSuffix)
+		case strings.HasSuffix(name, htmlTemplateSuffix):
+			sources = htmlSources
+			name = strings.TrimSuffix(name, htmlTemplateSuffix)
+		default:
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return fmt.Errorf("unable to read template %s %w", entry.Name(), err)
+		}
+		sources[name] = string(content)
+	}
+	return nil
+}
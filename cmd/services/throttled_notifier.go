@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitInterval = time.Hour
+
+type duplicateState struct {
+	last  Notification
+	count int
+}
+
+// throttledNotifier collapses repeated notifications (same event, trigger and
+// destination) within the dedup window into one follow-up message with a
+// repeat count, and holds back everything over the rate limit until the end
+// of the current interval where it is sent as a single summary.
+type throttledNotifier struct {
+	mu           sync.Mutex
+	name         string
+	next         Notifier
+	dedupWindow  time.Duration
+	rateLimit    int
+	rateInterval time.Duration
+	windowStart  time.Time
+	sentInWindow int
+	suppressed   []Notification
+	duplicates   map[string]*duplicateState
+}
+
+func newThrottledNotifier(name string, next Notifier, dedupWindow time.Duration, rateLimit int, rateInterval time.Duration) *throttledNotifier {
+	if rateInterval <= 0 {
+		rateInterval = defaultRateLimitInterval
+	}
+	return &throttledNotifier{
+		name:         name,
+		next:         next,
+		dedupWindow:  dedupWindow,
+		rateLimit:    rateLimit,
+		rateInterval: rateInterval,
+		windowStart:  time.Now(),
+		duplicates:   make(map[string]*duplicateState),
+	}
+}
+
+func (t *throttledNotifier) Notify(notification Notification) error {
+	t.mu.Lock()
+
+	if t.dedupWindow > 0 {
+		key := duplicateKey(notification)
+		if st, ok := t.duplicates[key]; ok {
+			st.last = notification
+			st.count++
+			t.mu.Unlock()
+			return nil
+		}
+		t.duplicates[key] = &duplicateState{last: notification}
+		time.AfterFunc(t.dedupWindow, func() { t.flushDuplicates(key) })
+	}
+
+	if !t.allow(notification) {
+		t.mu.Unlock()
+		return nil
+	}
+	t.mu.Unlock()
+
+	return t.next.Notify(notification)
+}
+
+// allow applies the rate limit, callers must hold t.mu.
+func (t *throttledNotifier) allow(notification Notification) bool {
+	if t.rateLimit <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(t.windowStart) >= t.rateInterval {
+		t.windowStart = now
+		t.sentInWindow = 0
+	}
+	if t.sentInWindow < t.rateLimit {
+		t.sentInWindow++
+		return true
+	}
+
+	if len(t.suppressed) == 0 {
+		time.AfterFunc(time.Until(t.windowStart.Add(t.rateInterval)), t.flushSuppressed)
+	}
+	t.suppressed = append(t.suppressed, notification)
+	return false
+}
+
+func (t *throttledNotifier) flushDuplicates(key string) {
+	t.mu.Lock()
+	st := t.duplicates[key]
+	delete(t.duplicates, key)
+	if st == nil || st.count == 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	n := st.last
+	n.Subject = fmt.Sprintf("%s (repeated %d times)", n.Subject, st.count)
+	n.Body = fmt.Sprintf("This notification was repeated %d more times within %s, the latest occurrence follows.\n\n%s",
+		st.count, t.dedupWindow, n.Body)
+	allowed := t.allow(n)
+	t.mu.Unlock()
+
+	if !allowed {
+		return
+	}
+	if err := t.next.Notify(n); err != nil {
+		log.Printf("Warning: throttledNotifier - channel %s failed to send repeat summary %v", t.name, err)
+	}
+}
+
+func (t *throttledNotifier) flushSuppressed() {
+	t.mu.Lock()
+	suppressed := t.suppressed
+	t.suppressed = nil
+	t.windowStart = time.Now()
+	t.sentInWindow = 1
+	t.mu.Unlock()
+
+	if len(suppressed) == 0 {
+		return
+	}
+
+	// the summary takes event, trigger and destination of its most severe
+	// entry so incident channels key it like that notification
+	summary := Notification{
+		Event:       suppressed[0].Event,
+		Severity:    SeverityInfo,
+		Subject:     fmt.Sprintf("%d notifications held back by rate limit", len(suppressed)),
+		Trigger:     suppressed[0].Trigger,
+		Destination: suppressed[0].Destination,
+	}
+	lines := make([]string, 0, len(suppressed))
+	for _, n := range suppressed {
+		if n.Severity.rank() > summary.Severity.rank() {
+			summary.Severity = n.Severity
+			summary.Event = n.Event
+			summary.Trigger = n.Trigger
+			summary.Destination = n.Destination
+		}
+		lines = append(lines, fmt.Sprintf("- [%s] %s", n.Severity, n.Subject))
+	}
+	summary.Body = fmt.Sprintf("More than %d notifications were raised within %s:\n\n%s",
+		t.rateLimit, t.rateInterval, strings.Join(lines, "\n"))
+
+	if err := t.next.Notify(summary); err != nil {
+		log.Printf("Warning: throttledNotifier - channel %s failed to send rate limit summary %v", t.name, err)
+	}
+}
+
+// duplicateKey leaves out the error text, which often carries timestamps or
+// request ids and would make every failure look new.
+func duplicateKey(n Notification) string {
+	return strings.Join([]string{string(n.Event), n.Trigger, n.Destination}, "|")
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps every notification it receives and fails the
+// first failures of them.
+type recordingNotifier struct {
+	mu       sync.Mutex
+	received []Notification
+	failures int
+}
+
+func (r *recordingNotifier) Notify(notification Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, notification)
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("channel unavailable")
+	}
+	return nil
+}
+
+func (r *recordingNotifier) notifications() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Notification{}, r.received...)
+}
+
+// waitForNotifications polls until n notifications were received.
+func waitForNotifications(t *testing.T, r *recordingNotifier, n int) []Notification {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := r.notifications()
+		if len(got) >= n || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestThrottledNotifierCollapsesDuplicates(t *testing.T) {
+	next := &recordingNotifier{}
+	throttled := newThrottledNotifier("test", next, 50*time.Millisecond, 0, 0)
+
+	failed := Notification{Event: BackupFailedEvent, Severity: SeverityCritical, Subject: "backup failed", Trigger: "scheduled"}
+	for i := 0; i < 3; i++ {
+		// the error text differs on every attempt and must not defeat the dedup
+		failed.Error = time.Now().String()
+		if err := throttled.Notify(failed); err != nil {
+			t.Fatalf("Notify() = %v", err)
+		}
+	}
+	if err := throttled.Notify(Notification{Event: BackupFailedEvent, Severity: SeverityCritical, Trigger: "manual"}); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+
+	got := waitForNotifications(t, next, 3)
+	if len(got) != 3 {
+		t.Fatalf("got %d notifications, want first, other trigger and repeat summary: %+v", len(got), got)
+	}
+	if got[0].Subject != "backup failed" || got[1].Trigger != "manual" {
+		t.Errorf("unexpected immediate notifications %+v", got[:2])
+	}
+	if !strings.Contains(got[2].Subject, "repeated 2 times") || got[2].Error != failed.Error {
+		t.Errorf("repeat summary %+v, want the latest occurrence repeated 2 times", got[2])
+	}
+}
+
+func TestThrottledNotifierRateLimits(t *testing.T) {
+	next := &recordingNotifier{}
+	throttled := newThrottledNotifier("test", next, 0, 2, 50*time.Millisecond)
+
+	events := []Notification{
+		{Event: BackupSucceededEvent, Severity: SeverityInfo, Subject: "one"},
+		{Event: BackupSucceededEvent, Severity: SeverityInfo, Subject: "two"},
+		{Event: RetentionFailedEvent, Severity: SeverityWarning, Subject: "three", Destination: "google_drive"},
+		{Event: BackupFailedEvent, Severity: SeverityCritical, Subject: "four", Trigger: "scheduled", Destination: "google_drive:folder"},
+	}
+	for _, n := range events {
+		if err := throttled.Notify(n); err != nil {
+			t.Fatalf("Notify() = %v", err)
+		}
+	}
+	if got := next.notifications(); len(got) != 2 {
+		t.Fatalf("got %d notifications within the limit, want 2", len(got))
+	}
+
+	got := waitForNotifications(t, next, 3)
+	if len(got) != 3 {
+		t.Fatalf("got %d notifications, want a rate limit summary", len(got))
+	}
+	summary := got[2]
+	if !strings.Contains(summary.Subject, "2 notifications held back") ||
+		!strings.Contains(summary.Body, "three") || !strings.Contains(summary.Body, "four") {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if summary.Severity != SeverityCritical || summary.Event != BackupFailedEvent ||
+		summary.Trigger != "scheduled" || summary.Destination != "google_drive:folder" {
+		t.Errorf("summary %+v does not carry its most severe entry", summary)
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	vault "github.com/hashicorp/vault/api"
+	"sync"
+	"time"
+)
+
+// TokenState describes the Vault auth token as seen by the renewal loop.
+type TokenState struct {
+	Healthy             bool       `json:"healthy"`
+	Renewable           bool       `json:"renewable"`
+	ExpiresAt           *time.Time `json:"expires_at,omitempty"`
+	LastLogin           *time.Time `json:"last_login,omitempty"`
+	LastRenewal         *time.Time `json:"last_renewal,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// TokenHolder keeps the current Vault auth token. Logins and renewals go
+// through it so the client, the snapshot code and the websocket dialer all
+// use the same token.
+type TokenHolder struct {
+	mu     sync.RWMutex
+	client *vault.Client
+	secret *vault.Secret
+	state  TokenState
+}
+
+func newTokenHolder(client *vault.Client) *TokenHolder {
+	return &TokenHolder{client: client}
+}
+
+func (h *TokenHolder) Token() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.secret == nil || h.secret.Auth == nil {
+		return ""
+	}
+	return h.secret.Auth.ClientToken
+}
+
+func (h *TokenHolder) Secret() *vault.Secret {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.secret
+}
+
+func (h *TokenHolder) State() TokenState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.state
+}
+
+// loggedIn stores the token of a successful login and sets it on the client.
+func (h *TokenHolder) loggedIn(secret *vault.Secret) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.secret = secret
+	h.client.SetToken(secret.Auth.ClientToken)
+	h.state = TokenState{
+		Healthy:   true,
+		Renewable: secret.Auth.Renewable,
+		ExpiresAt: leaseExpiry(now, secret.Auth.LeaseDuration),
+		LastLogin: &now,
+	}
+}
+
+func (h *TokenHolder) renewed(secret *vault.Secret) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.state.Healthy = true
+	h.state.LastRenewal = &now
+	h.state.ConsecutiveFailures = 0
+	h.state.LastError = ""
+	if secret != nil && secret.Auth != nil {
+		h.state.Renewable = secret.Auth.Renewable
+		h.state.ExpiresAt = leaseExpiry(now, secret.Auth.LeaseDuration)
+	}
+}
+
+// failed records a failed login. The token stays usable until it expires.
+func (h *TokenHolder) failed(err error) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state.ConsecutiveFailures++
+	h.state.LastError = err.Error()
+	if h.state.ExpiresAt == nil || time.Now().After(*h.state.ExpiresAt) {
+		h.state.Healthy = false
+	}
+	return h.state.ConsecutiveFailures
+}
+
+func leaseExpiry(now time.Time, leaseDuration int) *time.Time {
+	if leaseDuration <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(time.Duration(leaseDuration) * time.Second)
+	return &expiresAt
+}
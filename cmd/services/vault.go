@@ -2,16 +2,23 @@ package services
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"github.com/gorilla/websocket"
 	vault "github.com/hashicorp/vault/api"
-	auth "github.com/hashicorp/vault/api/auth/approle"
 	"log"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 	"vault_backup/cmd/config"
 )
 
 type Vault struct {
-	client *vault.Client
+	client    *vault.Client
+	tlsConfig *tls.Config
+	tokens    *TokenHolder
 }
 
 type renewResult uint8
@@ -22,60 +29,259 @@ const (
 	expiringAuthToken
 )
 
-func GetVaultAppRoleClient(ctx context.Context, config config.AppConfig) (*Vault, *vault.Secret, error) {
-	client, err := vault.NewClient(&vault.Config{Address: config.VaultConfig.Address})
+const (
+	tokenReloginInitialBackoff = 5 * time.Second
+	tokenReloginMaxBackoff     = 5 * time.Minute
+	// tokenReloginAlertAfter consecutive failed logins trigger a notification
+	tokenReloginAlertAfter = 3
+)
+
+// GetVaultClient creates the Vault client and logs in with the configured
+// auth method.
+func GetVaultClient(ctx context.Context, config config.AppConfig) (*Vault, error) {
+	clientConfig, err := newVaultClientConfig(config.VaultConfig)
 	if err != nil {
-		log.Fatalf("GetVaultAppRoleClient: unable to initialize Vault client: %v", err)
+		return nil, fmt.Errorf("GetVaultClient: %w", err)
 	}
 
+	client, err := vault.NewClient(clientConfig)
 	if err != nil {
-		return nil, nil, fmt.Errorf("GetVaultAppRoleClient: unable to initialize AppRole auth method: %w", err)
+		return nil, fmt.Errorf("GetVaultClient: unable to initialize Vault client: %w", err)
+	}
+	if config.VaultConfig.Namespace != "" {
+		client.SetNamespace(config.VaultConfig.Namespace)
 	}
 
 	v := &Vault{
-		client: client,
+		client:    client,
+		tlsConfig: clientConfig.TLSConfig(),
+		tokens:    newTokenHolder(client),
 	}
 
-	token, err := v.login(ctx, config)
-	if err != nil {
-		return nil, nil, fmt.Errorf("GetVaultAppRoleClient: Vault login error: %w", err)
+	if _, err := v.login(ctx, config); err != nil {
+		return nil, fmt.Errorf("GetVaultClient: Vault login error: %w", err)
 	}
 
 	log.Println("connecting to Vault: success!")
 
-	return v, token, nil
+	return v, nil
 }
 
-func (v *Vault) GetKVSecret(ctx context.Context, mountPath, secretPath string) (*vault.KVSecret, error) {
-	kvSecret, err := v.client.KVv2(mountPath).Get(ctx, secretPath)
+// newVaultClientConfig applies the TLS, timeout and retry settings on top of
+// Vault's defaults. Headers (and with them the namespace) are kept on clones.
+func newVaultClientConfig(vaultConfig config.VaultConfig) (*vault.Config, error) {
+	clientConfig := vault.DefaultConfig()
+	if clientConfig.Error != nil {
+		return nil, fmt.Errorf("invalid Vault client environment %w", clientConfig.Error)
+	}
+	clientConfig.Address = vaultConfig.Address
+	clientConfig.MaxRetries = vaultConfig.MaxRetries
+	clientConfig.CloneHeaders = true
+
+	if vaultConfig.Timeout != "" {
+		timeout, err := time.ParseDuration(vaultConfig.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vault timeout %w", err)
+		}
+		clientConfig.Timeout = timeout
+	}
+
+	tlsConfig := &vault.TLSConfig{
+		CACert:        vaultConfig.TLS.CACert,
+		CAPath:        vaultConfig.TLS.CAPath,
+		ClientCert:    vaultConfig.TLS.ClientCert,
+		ClientKey:     vaultConfig.TLS.ClientKey,
+		TLSServerName: vaultConfig.TLS.ServerName,
+		Insecure:      vaultConfig.TLS.InsecureSkipVerify,
+	}
+	if vaultConfig.Auth.Method == certAuthMethod && vaultConfig.Auth.ClientCertFile != "" {
+		tlsConfig.ClientCert = vaultConfig.Auth.ClientCertFile
+		tlsConfig.ClientKey = vaultConfig.Auth.ClientKeyFile
+	}
+	if tlsConfig.Insecure {
+		log.Println("Warning: Vault server certificate verification is disabled (vault.tls.insecure_skip_verify)")
+	}
+	if err := clientConfig.ConfigureTLS(tlsConfig); err != nil {
+		return nil, fmt.Errorf("unable to configure Vault TLS %w", err)
+	}
+
+	return clientConfig, nil
+}
+
+func (v *Vault) Tokens() *TokenHolder {
+	return v.tokens
+}
+
+// websocketDialer returns a dialer and request header for Vault's websocket
+// endpoints, sharing the client's TLS settings, namespace and current token.
+func (v *Vault) websocketDialer() (*websocket.Dialer, http.Header) {
+	dialer := *websocket.DefaultDialer
+	if v.tlsConfig != nil {
+		dialer.TLSClientConfig = v.tlsConfig.Clone()
+	}
+
+	header := http.Header{"X-Vault-Token": []string{v.tokens.Token()}}
+	if namespace := v.client.Namespace(); namespace != "" {
+		header.Set(vault.NamespaceHeaderName, namespace)
+	}
+	return &dialer, header
+}
+
+// ReadSecret reads the secret described by ref from a KV v1 or v2 mount,
+// honouring a pinned version.
+func (v *Vault) ReadSecret(ctx context.Context, ref config.SecretRef) (*vault.KVSecret, error) {
+	if err := ref.Validate(); err != nil {
+		return nil, fmt.Errorf("ReadSecret: %w", err)
+	}
+
+	var kvSecret *vault.KVSecret
+	var err error
+	switch {
+	case ref.KVVersion == 1:
+		kvSecret, err = v.client.KVv1(ref.Mount).Get(ctx, ref.Path)
+	case ref.Version > 0:
+		kvSecret, err = v.client.KVv2(ref.Mount).GetVersion(ctx, ref.Path, ref.Version)
+	default:
+		kvSecret, err = v.client.KVv2(ref.Mount).Get(ctx, ref.Path)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("GetKVSecret: error while getting secret from vault %w", err)
+		return nil, fmt.Errorf("ReadSecret: unable to read secret %s %w", ref, err)
+	}
+	if kvSecret == nil || kvSecret.Data == nil {
+		return nil, fmt.Errorf("ReadSecret: secret %s has no data", ref)
 	}
 
 	return kvSecret, nil
 }
 
+func (v *Vault) GetKVSecretField(ctx context.Context, ref config.SecretRef) (string, error) {
+	if ref.Field == "" {
+		return "", fmt.Errorf("GetKVSecretField: no field configured for secret %s", ref)
+	}
+
+	fields, err := v.GetKVSecretFields(ctx, ref, ref.Field)
+	if err != nil {
+		return "", fmt.Errorf("GetKVSecretField: %w", err)
+	}
+	return fields[ref.Field], nil
+}
+
+// GetKVSecretFields returns the string values for the given logical names,
+// resolved through the secret's field mapping.
+func (v *Vault) GetKVSecretFields(ctx context.Context, ref config.SecretRef, names ...string) (map[string]string, error) {
+	kvSecret, err := v.ReadSecret(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("GetKVSecretFields: %w", err)
+	}
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		field := ref.FieldName(name)
+		raw, ok := kvSecret.Data[field]
+		if !ok {
+			return nil, fmt.Errorf("GetKVSecretFields: field %q missing in secret %s", field, ref)
+		}
+		value, ok := raw.(string)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("GetKVSecretFields: field %q in secret %s is not a non-empty string", field, ref)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// ValidateAdminToken checks that the token is valid and grants the given
+// capability on capabilityPath, so admin API access follows Vault ACLs.
+func (v *Vault) ValidateAdminToken(ctx context.Context, token, capabilityPath, capability string) error {
+	client, err := v.client.Clone()
+	if err != nil {
+		return fmt.Errorf("ValidateAdminToken: unable to clone Vault client %w", err)
+	}
+	client.SetToken(token)
+
+	if _, err := client.Auth().Token().LookupSelfWithContext(ctx); err != nil {
+		return fmt.Errorf("ValidateAdminToken: token lookup failed %w", err)
+	}
+
+	capabilities, err := client.Sys().CapabilitiesSelfWithContext(ctx, capabilityPath)
+	if err != nil {
+		return fmt.Errorf("ValidateAdminToken: unable to read token capabilities %w", err)
+	}
+
+	for _, c := range capabilities {
+		if c == capability || c == "root" {
+			return nil
+		}
+	}
+	return fmt.Errorf("ValidateAdminToken: token lacks %s capability on %s", capability, capabilityPath)
+}
+
+// IssueCertificate issues a certificate from the PKI secrets engine mounted
+// at mount using role and returns it with the issuing chain appended.
+func (v *Vault) IssueCertificate(ctx context.Context, mount, role, commonName string, altNames []string, ttl string) (*tls.Certificate, error) {
+	secret, err := v.client.Logical().WriteWithContext(ctx, fmt.Sprintf("%s/issue/%s", mount, role), map[string]interface{}{
+		"common_name": commonName,
+		"alt_names":   strings.Join(altNames, ","),
+		"ttl":         ttl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("IssueCertificate: error while issuing certificate %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("IssueCertificate: no certificate returned from %s", mount)
+	}
+
+	certPEM, _ := secret.Data["certificate"].(string)
+	keyPEM, _ := secret.Data["private_key"].(string)
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, c := range chain {
+			if s, ok := c.(string); ok {
+				certPEM += "\n" + s
+			}
+		}
+	} else if issuingCA, ok := secret.Data["issuing_ca"].(string); ok {
+		certPEM += "\n" + issuingCA
+	}
+
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("IssueCertificate: invalid certificate returned from %s %w", mount, err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("IssueCertificate: unable to parse issued certificate %w", err)
+	}
+
+	return &cert, nil
+}
+
 func (v *Vault) login(ctx context.Context, config config.AppConfig) (*vault.Secret, error) {
-	appRoleAuth, err := auth.NewAppRoleAuth(
-		config.VaultConfig.AppRoleId,
-		&auth.SecretID{FromString: config.VaultConfig.AppSecretId})
+	authMethod, err := newAuthMethod(config.VaultConfig.Auth, config.VaultConfig)
 	if err != nil {
-		return nil, fmt.Errorf("unable to initialize AppRole auth method: %w", err)
+		return nil, fmt.Errorf("unable to initialize auth method: %w", err)
 	}
 
-	authInfo, err := v.client.Auth().Login(ctx, appRoleAuth)
+	authInfo, err := v.client.Auth().Login(ctx, authMethod)
 	if err != nil {
-		return nil, fmt.Errorf("unable to login to AppRole auth method: %w", err)
+		return nil, fmt.Errorf("unable to login to Vault: %w", err)
 	}
 
-	if authInfo == nil {
+	if authInfo == nil || authInfo.Auth == nil {
 		return nil, fmt.Errorf("login: no auth info was returned after login")
 	}
+	v.tokens.loggedIn(authInfo)
 
 	return authInfo, nil
 }
 
-func (v *Vault) RaftSnapshot(snapshotPath string) (*os.File, error) {
+// RaftSnapshot writes a snapshot taken through source, or the configured
+// node when source is nil, to snapshotPath.
+func (v *Vault) RaftSnapshot(ctx context.Context, source *SnapshotSource, snapshotPath string, onProgress func(written int64)) (*os.File, error) {
+	client := v.client
+	if source != nil {
+		client = source.client
+	}
+
 	snapshotFile, err := os.OpenFile(snapshotPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
 		log.Printf("Snapshot file at %s could not be created", snapshotPath)
@@ -84,9 +290,8 @@ func (v *Vault) RaftSnapshot(snapshotPath string) (*os.File, error) {
 	}
 	defer snapshotFileClose(snapshotFile)
 
-	err = v.client.Sys().RaftSnapshot(snapshotFile)
+	err = client.Sys().RaftSnapshotWithContext(ctx, newProgressWriter(snapshotFile, onProgress))
 	if err != nil {
-		snapshotFile.Close()
 		fmt.Println("Vault Raft snapshot invocation failed")
 		fmt.Println(err)
 		return nil, err
@@ -95,34 +300,76 @@ func (v *Vault) RaftSnapshot(snapshotPath string) (*os.File, error) {
 	return snapshotFile, nil
 }
 
-func (v *Vault) RenewTokenPeriodically(ctx context.Context, authToken *vault.Secret, config config.AppConfig) {
+// RenewTokenPeriodically keeps the auth token alive until ctx is done. When
+// the token can no longer be renewed it logs in again, retrying with backoff
+// and alerting once re-login keeps failing.
+func (v *Vault) RenewTokenPeriodically(ctx context.Context, config config.AppConfig, notifier Notifier) {
 
 	log.Println("Renew / Recreate secrets loop: begin")
 	defer log.Println("Renew / Recreate secrets loop: end")
 
-	currentAuthToken := authToken
 	for {
-		renewed, err := v.renewLeases(ctx, currentAuthToken)
+		renewed, err := v.renewLeases(ctx, v.tokens.Secret())
 		if err != nil {
-			log.Fatalf("Renew error: %v", err) // simplified error handling
+			log.Printf("Renew error: %v", err)
 		}
 
 		if renewed&exitRequested != 0 {
 			return
 		}
 
-		if renewed&expiringAuthToken != 0 {
+		if renewed&(expiringAuthToken|renewError) != 0 {
 			log.Printf("Auth token: can no longer be renewed; will log in again")
-
-			authToken, err := v.login(ctx, config)
-			if err != nil {
-				log.Fatalf("Login authentication error: %v", err)
+			if !v.reloginWithBackoff(ctx, config, notifier) {
+				return
 			}
-			currentAuthToken = authToken
 		}
 	}
 }
 
+// reloginWithBackoff logs in until it succeeds or ctx is done, reporting
+// whether a new login happened.
+func (v *Vault) reloginWithBackoff(ctx context.Context, config config.AppConfig, notifier Notifier) bool {
+	previousToken := v.tokens.Token()
+	backoff := tokenReloginInitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		_, err := v.login(ctx, config)
+		if err == nil {
+			break
+		}
+
+		failures := v.tokens.failed(err)
+		log.Printf("Login authentication error (attempt %d): %v", attempt, err)
+		if failures == tokenReloginAlertAfter {
+			SendNotification(notifier, Notification{
+				Event:    TokenReloginFailedEvent,
+				Severity: SeverityCritical,
+				Error:    err.Error(),
+				Count:    failures,
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, tokenReloginMaxBackoff)
+	}
+
+	if v.tokens.Token() == previousToken {
+		// token file still holds the same token
+		return true
+	}
+
+	SendNotification(notifier, Notification{
+		Event:    TokenReloginEvent,
+		Severity: SeverityInfo,
+	})
+	return true
+}
+
 func (v *Vault) renewLeases(ctx context.Context, authToken *vault.Secret) (renewResult, error) {
 	log.Println("Renew cycle: begin")
 	defer log.Println("Renew cycle: end")
@@ -157,6 +404,7 @@ func (v *Vault) renewLeases(ctx context.Context, authToken *vault.Secret) (renew
 		// renewal takes place and includes metadata about the renewal.
 		case info := <-authTokenWatcher.RenewCh():
 			log.Printf("Auth token: successfully renewed; remaining duration: %ds\n", info.Secret.Auth.LeaseDuration)
+			v.tokens.renewed(info.Secret)
 		}
 	}
 }
@@ -164,7 +412,6 @@ func (v *Vault) renewLeases(ctx context.Context, authToken *vault.Secret) (renew
 func snapshotFileClose(snapshotFile *os.File) {
 	err := snapshotFile.Close()
 	if err != nil {
-		fmt.Println("Vault raft snapshot file failed to close")
-		log.Fatalln(err)
+		log.Printf("Vault raft snapshot file failed to close %v", err)
 	}
 }
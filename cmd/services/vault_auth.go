@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	vault "github.com/hashicorp/vault/api"
+	auth "github.com/hashicorp/vault/api/auth/approle"
+	"os"
+	"strings"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const (
+	appRoleAuthMethod    = "approle"
+	kubernetesAuthMethod = "kubernetes"
+	jwtAuthMethod        = "jwt"
+	certAuthMethod       = "cert"
+	userpassAuthMethod   = "userpass"
+	tokenFileAuthMethod  = "token_file"
+
+	defaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// tokenFileRecheckInterval is used as lease duration for non-expiring
+	// tokens read from a file, so a rotated sink file is picked up.
+	tokenFileRecheckInterval = 5 * time.Minute
+)
+
+// newAuthMethod returns the Vault auth method selected by vault.auth.method.
+// Credentials kept in files are read on every login, so rotated files are
+// used on re-login.
+func newAuthMethod(authConfig config.VaultAuthConfig, vaultConfig config.VaultConfig) (vault.AuthMethod, error) {
+	method := authConfig.Method
+	if method == "" {
+		method = appRoleAuthMethod
+	}
+	mount := authConfig.Mount
+	if mount == "" {
+		mount = method
+	}
+
+	switch method {
+	case appRoleAuthMethod:
+		// the file is read by the AppRole method on every login
+		secretId := &auth.SecretID{FromString: vaultConfig.AppSecretId}
+		if vaultConfig.AppSecretIdFile != "" {
+			secretId = &auth.SecretID{FromFile: vaultConfig.AppSecretIdFile}
+		}
+		opts := []auth.LoginOption{auth.WithMountPath(mount)}
+		if vaultConfig.AppSecretIdWrapped {
+			// a wrapping token unwraps once, re-logins need a fresh one from the file
+			if vaultConfig.AppSecretIdFile == "" {
+				return nil, fmt.Errorf("app_secret_id_wrapped requires app_secret_id_file")
+			}
+			opts = append(opts, auth.WithWrappingToken())
+		}
+		return auth.NewAppRoleAuth(vaultConfig.AppRoleId, secretId, opts...)
+	case kubernetesAuthMethod, jwtAuthMethod:
+		jwtFile := authConfig.JWTFile
+		if jwtFile == "" && method == kubernetesAuthMethod {
+			jwtFile = defaultKubernetesTokenPath
+		}
+		if authConfig.Role == "" || jwtFile == "" {
+			return nil, fmt.Errorf("%s auth requires role and jwt_file", method)
+		}
+		return &jwtAuth{mount: mount, role: authConfig.Role, jwtFile: jwtFile}, nil
+	case certAuthMethod:
+		if (authConfig.ClientCertFile == "" || authConfig.ClientKeyFile == "") &&
+			(vaultConfig.TLS.ClientCert == "" || vaultConfig.TLS.ClientKey == "") {
+			return nil, fmt.Errorf("cert auth requires client_cert_file and client_key_file or vault.tls client certificate")
+		}
+		return &certAuth{mount: mount, role: authConfig.Role}, nil
+	case userpassAuthMethod:
+		if authConfig.Username == "" || (authConfig.Password == "" && authConfig.PasswordFile == "") {
+			return nil, fmt.Errorf("userpass auth requires username and a password or password_file")
+		}
+		return &userpassAuth{
+			mount:        mount,
+			username:     authConfig.Username,
+			password:     authConfig.Password,
+			passwordFile: authConfig.PasswordFile}, nil
+	case tokenFileAuthMethod:
+		if authConfig.TokenFile == "" {
+			return nil, fmt.Errorf("token_file auth requires token_file")
+		}
+		return &tokenFileAuth{path: authConfig.TokenFile}, nil
+	}
+	return nil, fmt.Errorf("unknown auth method %q", method)
+}
+
+// jwtAuth logs in with a JWT read from a file, which covers both the
+// kubernetes (service account token) and the jwt/oidc auth methods.
+type jwtAuth struct {
+	mount   string
+	role    string
+	jwtFile string
+}
+
+func (a *jwtAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	jwt, err := readCredentialFile(a.jwtFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read jwt %w", err)
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", a.mount), map[string]interface{}{
+		"role": a.role,
+		"jwt":  jwt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in with %s auth %w", a.mount, err)
+	}
+	return secret, nil
+}
+
+// certAuth relies on the client certificate configured on the Vault client.
+type certAuth struct {
+	mount string
+	role  string
+}
+
+func (a *certAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	data := map[string]interface{}{}
+	if a.role != "" {
+		data["name"] = a.role
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", a.mount), data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in with cert auth %w", err)
+	}
+	return secret, nil
+}
+
+type userpassAuth struct {
+	mount        string
+	username     string
+	password     string
+	passwordFile string
+}
+
+func (a *userpassAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	password := a.password
+	if a.passwordFile != "" {
+		var err error
+		if password, err = readCredentialFile(a.passwordFile); err != nil {
+			return nil, fmt.Errorf("unable to read password %w", err)
+		}
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login/%s", a.mount, a.username), map[string]interface{}{
+		"password": password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in with userpass auth %w", err)
+	}
+	return secret, nil
+}
+
+// tokenFileAuth uses a token written by Vault Agent's file sink or an
+// operator. Logging in again re-reads the file.
+type tokenFileAuth struct {
+	path string
+}
+
+func (a *tokenFileAuth) Login(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+	token, err := readCredentialFile(a.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token %w", err)
+	}
+
+	lookupClient, err := client.Clone()
+	if err != nil {
+		return nil, fmt.Errorf("unable to clone Vault client %w", err)
+	}
+	lookupClient.SetToken(token)
+
+	lookup, err := lookupClient.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("token lookup failed %w", err)
+	}
+
+	renewable, _ := lookup.TokenIsRenewable()
+	ttl, _ := lookup.TokenTTL()
+	if ttl == 0 {
+		renewable = false
+		ttl = tokenFileRecheckInterval
+	}
+
+	return &vault.Secret{
+		Auth: &vault.SecretAuth{
+			ClientToken:   token,
+			Renewable:     renewable,
+			LeaseDuration: int(ttl.Seconds()),
+		},
+	}, nil
+}
+
+func readCredentialFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return value, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// VaultEvent is a change notification received from Vault's events API.
+type VaultEvent struct {
+	Id        string            `json:"id"`
+	Type      string            `json:"type"`
+	Operation string            `json:"operation,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Mount     string            `json:"mount,omitempty"`
+	Plugin    string            `json:"plugin,omitempty"`
+	Namespace string            `json:"namespace,omitempty"`
+	Modified  bool              `json:"modified"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Time      time.Time         `json:"time"`
+}
+
+// cloudEvent mirrors the CloudEvents envelope Vault sends with json=true.
+type cloudEvent struct {
+	Id   string    `json:"id"`
+	Time time.Time `json:"time"`
+	Data struct {
+		Event struct {
+			Id       string                 `json:"id"`
+			Metadata map[string]interface{} `json:"metadata"`
+		} `json:"event"`
+		EventType  string `json:"event_type"`
+		Namespace  string `json:"namespace"`
+		PluginInfo struct {
+			MountPath string `json:"mount_path"`
+			Plugin    string `json:"plugin"`
+		} `json:"plugin_info"`
+	} `json:"data"`
+}
+
+func parseVaultEvent(message []byte) (VaultEvent, error) {
+	var ce cloudEvent
+	if err := json.Unmarshal(message, &ce); err != nil {
+		return VaultEvent{}, fmt.Errorf("parseVaultEvent: invalid event %w", err)
+	}
+	if ce.Data.EventType == "" {
+		return VaultEvent{}, fmt.Errorf("parseVaultEvent: message has no event type")
+	}
+
+	metadata := make(map[string]string, len(ce.Data.Event.Metadata))
+	for k, v := range ce.Data.Event.Metadata {
+		metadata[k] = fmt.Sprint(v)
+	}
+
+	event := VaultEvent{
+		Id:        ce.Data.Event.Id,
+		Type:      ce.Data.EventType,
+		Operation: metadata["operation"],
+		Path:      metadata["path"],
+		Mount:     ce.Data.PluginInfo.MountPath,
+		Plugin:    ce.Data.PluginInfo.Plugin,
+		Namespace: ce.Data.Namespace,
+		Modified:  metadata["modified"] == "true",
+		Metadata:  metadata,
+		Time:      ce.Time,
+	}
+	if event.Id == "" {
+		event.Id = ce.Id
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return event, nil
+}
+
+// eventFilter selects events by path. Patterns use path.Match syntax, a
+// trailing "/**" matches everything below a prefix.
+type eventFilter struct {
+	include []string
+	exclude []string
+}
+
+func newEventFilter(include, exclude []string) (*eventFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return nil, fmt.Errorf("newEventFilter: invalid path pattern %q %w", pattern, err)
+		}
+	}
+	return &eventFilter{include: include, exclude: exclude}, nil
+}
+
+func (f *eventFilter) match(event VaultEvent) bool {
+	if len(f.include) > 0 && !matchAnyPath(f.include, event.Path) {
+		return false
+	}
+	return !matchAnyPath(f.exclude, event.Path)
+}
+
+func matchAnyPath(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+			// compare the prefix with as many leading segments of p
+			segments := strings.Split(p, "/")
+			n := strings.Count(prefix, "/") + 1
+			if len(segments) < n {
+				continue
+			}
+			if ok, _ := path.Match(prefix, strings.Join(segments[:n], "/")); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package services
+
+import "testing"
+
+func TestEventFilter(t *testing.T) {
+	filter, err := newEventFilter(
+		[]string{"secret/data/prod/**", "kv/data/shared"},
+		[]string{"secret/data/prod/tmp/**", "secret/data/prod/*/cache"})
+	if err != nil {
+		t.Fatalf("newEventFilter() = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"secret/data/prod/db", true},
+		{"secret/data/prod/team/db", true},
+		{"secret/data/prod", true},
+		{"kv/data/shared", true},
+		{"kv/data/shared/nested", false},
+		{"secret/data/staging/db", false},
+		{"secret/data/prod/tmp/scratch", false},
+		{"secret/data/prod/app/cache", false},
+		{"secret/data/prod/app/cache/nested", true},
+	}
+	for _, tt := range tests {
+		if got := filter.match(VaultEvent{Path: tt.path}); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestEventFilterWithoutIncludeMatchesAll(t *testing.T) {
+	filter, err := newEventFilter(nil, []string{"secret/data/tmp/**"})
+	if err != nil {
+		t.Fatalf("newEventFilter() = %v", err)
+	}
+	if !filter.match(VaultEvent{Path: "anything/at/all"}) {
+		t.Error("path outside the excludes was filtered")
+	}
+	if filter.match(VaultEvent{Path: "secret/data/tmp/x"}) {
+		t.Error("excluded path was matched")
+	}
+}
+
+func TestEventFilterRejectsInvalidPattern(t *testing.T) {
+	if _, err := newEventFilter([]string{"secret/[data"}, nil); err == nil {
+		t.Error("newEventFilter accepted an invalid pattern")
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	vault "github.com/hashicorp/vault/api"
+	"strings"
+)
+
+// WalkKVv2 calls fn for every secret below the KV v2 mount, paths are
+// relative to the mount.
+func (v *Vault) WalkKVv2(ctx context.Context, mount string, fn func(secretPath string) error) error {
+	return v.walkKVv2(ctx, strings.Trim(mount, "/"), "", fn)
+}
+
+func (v *Vault) walkKVv2(ctx context.Context, mount, prefix string, fn func(secretPath string) error) error {
+	secret, err := v.client.Logical().ListWithContext(ctx, fmt.Sprintf("%s/metadata/%s", mount, prefix))
+	if err != nil {
+		return fmt.Errorf("WalkKVv2: unable to list %s/%s %w", mount, prefix, err)
+	}
+	if secret == nil || secret.Data == nil {
+		// empty mount or folder
+		return nil
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+	for _, k := range keys {
+		key, ok := k.(string)
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(key, "/") {
+			if err := v.walkKVv2(ctx, mount, prefix+key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(prefix + key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Vault) GetKVv2Metadata(ctx context.Context, mount, secretPath string) (*vault.KVMetadata, error) {
+	metadata, err := v.client.KVv2(mount).GetMetadata(ctx, secretPath)
+	if err != nil {
+		return nil, fmt.Errorf("GetKVv2Metadata: unable to read metadata of %s/%s %w", mount, secretPath, err)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"vault_backup/cmd/config"
+)
+
+const defaultWatchdogCheckInterval = 5 * time.Minute
+
+type SLAState struct {
+	Trigger     string        `json:"trigger"`
+	Window      time.Duration `json:"window"`
+	LastSuccess *time.Time    `json:"last_success,omitempty"`
+	Breached    bool          `json:"breached"`
+}
+
+// BackupWatchdog is a dead man's switch: it notifies when no backup of a
+// trigger type succeeded within its SLA window and again once it recovers.
+type BackupWatchdog struct {
+	mu            sync.Mutex
+	appStatus     *FileAppStatus
+	notifier      Notifier
+	checkInterval time.Duration
+	windows       map[string]time.Duration
+	breached      map[string]bool
+}
+
+func GetBackupWatchdog(appConfig *config.AppConfig, appStatus *FileAppStatus, notifier Notifier) (*BackupWatchdog, error) {
+	w := &BackupWatchdog{
+		appStatus:     appStatus,
+		notifier:      notifier,
+		checkInterval: defaultWatchdogCheckInterval,
+		windows:       make(map[string]time.Duration),
+		breached:      make(map[string]bool),
+	}
+
+	if appConfig.Watchdog.CheckInterval != "" {
+		interval, err := time.ParseDuration(appConfig.Watchdog.CheckInterval)
+		if err != nil {
+			return nil, fmt.Errorf("GetBackupWatchdog: invalid check_interval %w", err)
+		}
+		w.checkInterval = interval
+	}
+
+	slas := map[Event]string{
+		WssEvent:       appConfig.Watchdog.EventBackupSLA,
+		ScheduledEvent: appConfig.Watchdog.ScheduledBackupSLA,
+	}
+	for event, sla := range slas {
+		if sla == "" {
+			continue
+		}
+		window, err := time.ParseDuration(sla)
+		if err != nil {
+			return nil, fmt.Errorf("GetBackupWatchdog: invalid SLA for %s %w", event, err)
+		}
+		w.windows[event.String()] = window
+	}
+
+	return w, nil
+}
+
+func (w *BackupWatchdog) Run(ctx context.Context) {
+	if len(w.windows) == 0 {
+		log.Println("Backup watchdog: no SLA configured, not running")
+		return
+	}
+
+	ticker := time.NewTicker(w.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		w.Check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check compares the last successful backup per trigger type with its SLA
+// window. Before the first success the application start time is used, so
+// a fresh install is not reported as breached right away.
+func (w *BackupWatchdog) Check() {
+	now := time.Now()
+
+	for trigger, window := range w.windows {
+		since := w.appStatus.StartTime()
+		lastSuccess, ok := w.appStatus.LastSuccess(trigger)
+		if ok {
+			since = lastSuccess
+		}
+		breached := now.Sub(since) > window
+
+		w.mu.Lock()
+		wasBreached := w.breached[trigger]
+		w.breached[trigger] = breached
+		w.mu.Unlock()
+
+		switch {
+		case breached && !wasBreached:
+			log.Printf("Backup watchdog: no successful %s backup within %s", trigger, window)
+			SendNotification(w.notifier, Notification{
+				Event:     SLABreachedEvent,
+				Severity:  SeverityCritical,
+				Trigger:   trigger,
+				SLAWindow: window,
+				Since:     since,
+			})
+		case !breached && wasBreached:
+			log.Printf("Backup watchdog: %s backups recovered", trigger)
+			SendNotification(w.notifier, Notification{
+				Event:       SLARecoveredEvent,
+				Severity:    SeverityInfo,
+				Trigger:     trigger,
+				SLAWindow:   window,
+				LastSuccess: lastSuccess,
+			})
+		}
+	}
+}
+
+func (w *BackupWatchdog) State() []SLAState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	states := make([]SLAState, 0, len(w.windows))
+	for trigger, window := range w.windows {
+		state := SLAState{Trigger: trigger, Window: window, Breached: w.breached[trigger]}
+		if t, ok := w.appStatus.LastSuccess(trigger); ok {
+			state.LastSuccess = &t
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Trigger < states[j].Trigger })
+
+	return states
+}
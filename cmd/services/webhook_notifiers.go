@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 15 * time.Second
+
+type SlackNotifier struct {
+	webhookUrl string
+	appName    string
+	client     *http.Client
+}
+
+func GetSlackNotifier(webhookUrl, appName string) *SlackNotifier {
+	return &SlackNotifier{webhookUrl: webhookUrl, appName: appName, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (s *SlackNotifier) Notify(notification Notification) error {
+	payload := map[string]interface{}{
+		"text": notification.Subject,
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "header",
+				"text": map[string]string{"type": "plain_text", "text": notification.Subject},
+			},
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": notification.Body},
+			},
+			map[string]interface{}{
+				"type": "context",
+				"elements": []interface{}{
+					map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("sent by *%s*", s.appName)},
+				},
+			},
+		},
+	}
+	if err := postWebhook(s.client, s.webhookUrl, payload); err != nil {
+		return fmt.Errorf("SlackNotifier: %w", err)
+	}
+	return nil
+}
+
+type TeamsNotifier struct {
+	webhookUrl string
+	appName    string
+	client     *http.Client
+}
+
+func GetTeamsNotifier(webhookUrl, appName string) *TeamsNotifier {
+	return &TeamsNotifier{webhookUrl: webhookUrl, appName: appName, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (t *TeamsNotifier) Notify(notification Notification) error {
+	payload := map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    notification.Subject,
+		"themeColor": fmt.Sprintf("%06X", severityColor(notification.Severity)),
+		"title":      notification.Subject,
+		"sections": []interface{}{
+			map[string]interface{}{
+				"activitySubtitle": t.appName,
+				"text":             notification.Body,
+			},
+		},
+	}
+	if err := postWebhook(t.client, t.webhookUrl, payload); err != nil {
+		return fmt.Errorf("TeamsNotifier: %w", err)
+	}
+	return nil
+}
+
+type DiscordNotifier struct {
+	webhookUrl string
+	appName    string
+	client     *http.Client
+}
+
+func GetDiscordNotifier(webhookUrl, appName string) *DiscordNotifier {
+	return &DiscordNotifier{webhookUrl: webhookUrl, appName: appName, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (d *DiscordNotifier) Notify(notification Notification) error {
+	payload := map[string]interface{}{
+		"username": d.appName,
+		"embeds": []interface{}{
+			map[string]interface{}{
+				"title":       notification.Subject,
+				"description": notification.Body,
+				"color":       severityColor(notification.Severity),
+				"timestamp":   time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+	}
+	if err := postWebhook(d.client, d.webhookUrl, payload); err != nil {
+		return fmt.Errorf("DiscordNotifier: %w", err)
+	}
+	return nil
+}
+
+// severityColor is the RGB accent of Teams and Discord messages.
+func severityColor(s Severity) int {
+	switch s {
+	case SeverityCritical:
+		return 0xD70000
+	case SeverityWarning:
+		return 0xF2A100
+	}
+	return 0x2EB886
+}
+
+func postWebhook(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to encode payload %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook url %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error while calling webhook %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("webhook responded with %s: %s", res.Status, msg)
+	}
+	return nil
+}